@@ -45,6 +45,8 @@ func main() {
 		}
 	}()
 
+	// 收到退出信号时持久化积压数据并退出
+	client.HandleSignals()
 	// 运行客户端
 	client.Run()
 }
@@ -7,87 +7,212 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
-// backlog 积压的数据，所有发送的数据都会到这里，只有服务端确认的数据才会被删除
-var backlog sync.Map
-var backlogCount int64 = 0
-var backlogCountMax int64 = 10000 // 内存中最大积压数据包条数
-var backlogCountMin int64 = 5000  // 持久化加载的最小量级
-var backlogFile = "%d.udb"
+// BacklogStore 积压数据的存储，所有put的数据都会先存入，只有服务端确认的数据才会被删除
+// 每个Client持有自己的 BacklogStore，通过 ClientConf.BacklogStore 指定，默认使用 UdbBacklogStore
+type BacklogStore interface {
+	Append(putData PutData) error              // 存入一条积压数据
+	Delete(putId int64) error                  // 服务端确认后删除
+	Range(fn func(putData PutData) bool) error // 遍历积压数据，fn返回false停止遍历
+	Len() int64                                // 积压数据条数
+	Flush() error                              // 持久化未落盘的数据
+	Close() error
+}
 
-func backlogAdd(putId int64, putData PutData) {
-	atomic.AddInt64(&backlogCount, 1)
-	backlog.Store(putId, putData)
-	backlogStorage()
+// MemoryBacklogStore 只存放在内存中的积压数据，进程退出后丢失
+type MemoryBacklogStore struct {
+	data  sync.Map
+	count int64
 }
 
-func backlogDel(putId int64) {
-	atomic.AddInt64(&backlogCount, -1)
-	backlog.Delete(putId)
+func NewMemoryBacklogStore() *MemoryBacklogStore {
+	return &MemoryBacklogStore{}
 }
 
-func backlogLen() int64 {
-	n := 0
-	backlog.Range(func(key, value any) bool {
-		n++
-		return true
+func (m *MemoryBacklogStore) Append(putData PutData) error {
+	if _, loaded := m.data.LoadOrStore(putData.Id, putData); !loaded {
+		atomic.AddInt64(&m.count, 1)
+	}
+	return nil
+}
+
+func (m *MemoryBacklogStore) Delete(putId int64) error {
+	if _, loaded := m.data.LoadAndDelete(putId); loaded {
+		atomic.AddInt64(&m.count, -1)
+	}
+	return nil
+}
+
+func (m *MemoryBacklogStore) Range(fn func(putData PutData) bool) error {
+	m.data.Range(func(key, value any) bool {
+		return fn(value.(PutData))
 	})
-	return int64(n)
+	return nil
+}
+
+func (m *MemoryBacklogStore) Len() int64 {
+	return atomic.LoadInt64(&m.count)
+}
+
+func (m *MemoryBacklogStore) Flush() error {
+	return nil
 }
 
-// backlogStorage 持久化方案: 保护内存不持续增长,尽力保证server掉线后数据不丢失，监听非强制kill把数据持久化
-// 只有当积压数据条数大于设定值(backlogCount > max)就将当前所有积压的数据持久化到磁盘，释放内存存放新的数据
-// 当积压数据条数小于设定值(backlogCount < min)就把持久化数据写到积压内存
+func (m *MemoryBacklogStore) Close() error {
+	return nil
+}
+
+// UdbBacklogStore 积压数据存放在内存，超过上限时持久化为 <unix>.udb 文件(每行一条json)，
+// 内存中积压数据较少时再加载持久化数据
+// 已持久化的数据在加载回内存前收到确认不会从 .udb 文件中删除，加载后(包括重启后)会再次发送，
+// 即至少送达一次，servers端的处理需要幂等；需要确认后不再重传时使用 WALBacklogStore
+type UdbBacklogStore struct {
+	MemoryBacklogStore
+	countMax int64  // 内存中最大积压数据包条数
+	countMin int64  // 持久化加载的最小量级
+	dir      string // 持久化文件存放的目录
+}
+
+var backlogFile = "%d.udb"
+
+// backlogDirs 同一进程内使用中的默认积压目录
+var backlogDirs sync.Map
+
+// defaultBacklogDir 默认的积压数据目录 DefaultBacklogDir/udb/<name>@<首选servers>
+// 重启后同名的Client连接同一servers时加载之前持久化的数据，同一进程内目录已被使用时加上序号，多个Client不会共用
+func defaultBacklogDir(name, server string) string {
+	base := filepath.Join(DefaultBacklogDir, "udb", strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|[]%`, r) {
+			return '_'
+		}
+		return r
+	}, name+"@"+server))
+	dir := base
+	for i := 2; ; i++ {
+		if _, loaded := backlogDirs.LoadOrStore(dir, struct{}{}); !loaded {
+			return dir
+		}
+		dir = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+// releaseBacklogDir Client关闭后默认积压目录可以被新的Client使用
+func releaseBacklogDir(dir string) {
+	if len(dir) > 0 {
+		backlogDirs.Delete(dir)
+	}
+}
+
+func NewUdbBacklogStore(countMax, countMin int64, dir string) *UdbBacklogStore {
+	if countMax <= 0 {
+		countMax = DefaultBacklogMax
+	}
+	if countMin <= 0 || countMin > countMax {
+		countMin = countMax / 2
+	}
+	if len(dir) < 1 {
+		dir = DefaultBacklogDir
+	}
+	return &UdbBacklogStore{
+		countMax: countMax,
+		countMin: countMin,
+		dir:      dir,
+	}
+}
+
+func (b *UdbBacklogStore) Append(putData PutData) error {
+	_ = b.MemoryBacklogStore.Append(putData)
+	b.storage()
+	return nil
+}
+
+// Delete 只删除内存中的积压数据，已持久化的数据仍会在加载后重传
+func (b *UdbBacklogStore) Delete(putId int64) error {
+	return b.MemoryBacklogStore.Delete(putId)
+}
+
+// Range 遍历内存中的积压数据，遍历完后如果内存中积压较少则加载持久化数据，在下次遍历时发送
+func (b *UdbBacklogStore) Range(fn func(putData PutData) bool) error {
+	_ = b.MemoryBacklogStore.Range(fn)
+	b.load()
+	return nil
+}
+
+// Flush 将内存中积压的数据全部持久化
+func (b *UdbBacklogStore) Flush() error {
+	return b.toUdb()
+}
+
+func (b *UdbBacklogStore) Close() error {
+	return b.Flush()
+}
+
+// storage 持久化方案: 保护内存不持续增长,尽力保证server掉线后数据不丢失，监听非强制kill把数据持久化
+// 只有当积压数据条数大于设定值(count > max)就将当前所有积压的数据持久化到磁盘，释放内存存放新的数据
+// 当积压数据条数小于设定值(count < min)就把持久化数据写到积压内存
 // 当监听到非强制kill把数据持久化
-func backlogStorage() {
-	if backlogLen() > backlogCountMax {
-		Error("触发持久化...... backlogCount = ", backlogCount, " 真实len = ", backlogLen())
-		toUdb()
+func (b *UdbBacklogStore) storage() {
+	if b.Len() > b.countMax {
+		Error("触发持久化...... backlogCount = ", b.Len())
+		if err := b.toUdb(); err != nil {
+			Error(err)
+		}
 	}
 }
 
-func toUdb() {
-	if backlogCount < 1 {
-		return
+func (b *UdbBacklogStore) toUdb() error {
+	if b.Len() < 1 {
+		return nil
+	}
+	if err := os.MkdirAll(b.dir, 0755); err != nil {
+		return err
 	}
-	file, err := os.OpenFile(fmt.Sprintf(backlogFile, time.Now().Unix()), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	fName := filepath.Join(b.dir, fmt.Sprintf(backlogFile, time.Now().Unix()))
+	file, err := os.OpenFile(fName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
-		Error(err)
+		return err
 	}
 	defer func() {
 		_ = file.Close()
 	}()
-	backlog.Range(func(key, value any) bool {
-		vb, vbErr := ObjToByte(value)
+	_ = b.MemoryBacklogStore.Range(func(putData PutData) bool {
+		vb, vbErr := ObjToByte(putData)
 		_, vbErr = file.Write(vb)
 		_, vbErr = file.Write([]byte("\n"))
 		if vbErr != nil {
 			Error(vbErr)
+			err = vbErr
+			return false
 		}
-		backlogDel(key.(int64))
+		_ = b.Delete(putData.Id)
 		return true
 	})
+	return err
 }
 
-// BacklogLoad 加载持久化数据 并消费
-func BacklogLoad() {
-	if backlogLen() > backlogCountMin {
-		Error("当前 队列 大于触发条件不加载 : ", backlogCount)
+// load 加载持久化数据 并消费
+func (b *UdbBacklogStore) load() {
+	if b.Len() > b.countMin {
+		Error("当前 队列 大于触发条件不加载 : ", b.Len())
 		return
 	}
-	files, err := ioutil.ReadDir(".")
+	files, err := ioutil.ReadDir(b.dir)
 	if err != nil {
-		Error("error reading directory:", err)
+		if !os.IsNotExist(err) {
+			Error("error reading directory:", err)
+		}
 		return
 	}
 	for _, file := range files {
 		extension := path.Ext(file.Name())
 		if extension == ".udb" {
-			filePath := "./" + file.Name()
+			filePath := filepath.Join(b.dir, file.Name())
 			// 删掉没用的文件
 			if file.Size() == 0 {
 				err := os.Remove(filePath)
@@ -98,8 +223,8 @@ func BacklogLoad() {
 			}
 			if file.Size() > 0 {
 				Info(file.Name())
-				fileToBacklog(filePath)
-				if backlogCount > backlogCountMin {
+				b.fileToBacklog(filePath)
+				if b.Len() > b.countMin {
 					break
 				}
 			}
@@ -107,7 +232,7 @@ func BacklogLoad() {
 	}
 }
 
-func fileToBacklog(fName string) {
+func (b *UdbBacklogStore) fileToBacklog(fName string) {
 	f, err := os.Open(fName)
 	if err != nil {
 		Error(err)
@@ -131,21 +256,16 @@ func fileToBacklog(fName string) {
 			continue
 		}
 		//Info("持久化 putData = ", putData)
-		if n < int64(backlogCountMax/2)+1 {
+		if n < b.countMax/2+1 {
 			putDataList1 = append(putDataList1, putData)
 		} else {
 			putDataList2 = append(putDataList2, putData)
 		}
-		if err != nil {
-			Error(err)
-			return
-		}
 	}
 	for _, v := range putDataList1 {
-		Error("加入 数据到队列 ... ")
-		backlogAdd(v.Id, v)
-		Error("加入后的count = ", backlogCount)
+		_ = b.Append(v)
 	}
+	Info("加入后的count = ", b.Len())
 	_ = f.Close()
 	resetBacklogFile(fName, putDataList2)
 }
@@ -0,0 +1,292 @@
+package udp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+/*
+
+WAL 积压数据预写日志，只追加写入，每条记录如下:
+_____________________________________________________________________
+|             |              |            |             |           |
+| crc32(4字节) | 长度(4字节)   | 类型(1字节) | putId(8字节) | 数据...    |
+|_____________|______________|____________|_____________|___________|
+
+crc32: 校验 类型+putId+数据
+类型: 1 新增积压数据(数据为PutData) 2 服务端确认删除(无数据)
+
+启动时顺序回放日志重建积压数据，遇到不完整或校验失败的记录(进程崩溃时写了一半)则从此处截断，
+失效记录多于有效数据时重写日志进行压缩
+
+*/
+
+const (
+	walRecordAdd    byte = 1
+	walRecordDel    byte = 2
+	walHeadLen           = 17
+	walFileName          = "backlog.wal"
+	walCompactMin        = 1024     // 失效记录少于该值时不压缩
+	walRecordMaxLen      = 16 << 20 // 单条记录数据的最大长度
+)
+
+// WALBacklogStore 基于预写日志的积压数据存储，进程崩溃后可恢复
+type WALBacklogStore struct {
+	mu       sync.Mutex
+	path     string
+	file     *os.File
+	data     map[int64]PutData
+	dead     int64 // 失效的记录条数
+	syncEach bool  // 每次写入后是否fsync
+}
+
+// NewWALBacklogStore 打开或创建 dir 下的日志文件并回放，syncEach 为true时每次写入都落盘
+func NewWALBacklogStore(dir string, syncEach bool) (*WALBacklogStore, error) {
+	if len(dir) < 1 {
+		dir = DefaultBacklogDir
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	w := &WALBacklogStore{
+		path:     filepath.Join(dir, walFileName),
+		data:     make(map[int64]PutData),
+		syncEach: syncEach,
+	}
+	if err := w.recover(); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(w.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	w.file = file
+	return w, nil
+}
+
+// recover 回放日志，截断末尾损坏的记录
+func (w *WALBacklogStore) recover() error {
+	file, err := os.OpenFile(w.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	var (
+		offset int64
+		head   = make([]byte, walHeadLen)
+		reader = bufio.NewReader(file)
+	)
+	for {
+		if _, err = io.ReadFull(reader, head); err != nil {
+			break
+		}
+		sum := binary.BigEndian.Uint32(head[0:4])
+		length := binary.BigEndian.Uint32(head[4:8])
+		if length > walRecordMaxLen {
+			err = ErrWALRecord
+			break
+		}
+		body := make([]byte, length)
+		if _, err = io.ReadFull(reader, body); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			break
+		}
+		crc := crc32.NewIEEE()
+		_, _ = crc.Write(head[8:])
+		_, _ = crc.Write(body)
+		if crc.Sum32() != sum {
+			err = ErrWALRecord
+			break
+		}
+		putId := int64(binary.BigEndian.Uint64(head[9:17]))
+		switch head[8] {
+		case walRecordAdd:
+			putData := PutData{}
+			if bErr := ByteToObj(body, &putData); bErr != nil {
+				err = bErr
+				break
+			}
+			w.data[putId] = putData
+		case walRecordDel:
+			if _, ok := w.data[putId]; ok {
+				delete(w.data, putId)
+				w.dead += 2
+			} else {
+				w.dead++
+			}
+		default:
+			err = ErrWALRecord
+		}
+		if err != nil {
+			break
+		}
+		offset += int64(walHeadLen) + int64(length)
+	}
+	if err == io.EOF {
+		return nil
+	}
+	// 末尾的记录不完整或已损坏，从最后一条完整的记录处截断
+	ErrorF("积压日志 %s 在 offset:%d 处损坏，截断 err: %v", w.path, offset, err)
+	return file.Truncate(offset)
+}
+
+func walRecord(typ byte, putId int64, body []byte) []byte {
+	record := make([]byte, walHeadLen+len(body))
+	binary.BigEndian.PutUint32(record[4:8], uint32(len(body)))
+	record[8] = typ
+	binary.BigEndian.PutUint64(record[9:17], uint64(putId))
+	copy(record[walHeadLen:], body)
+	binary.BigEndian.PutUint32(record[0:4], crc32.ChecksumIEEE(record[8:]))
+	return record
+}
+
+func (w *WALBacklogStore) write(record []byte) error {
+	if _, err := w.file.Write(record); err != nil {
+		return err
+	}
+	if w.syncEach {
+		return w.file.Sync()
+	}
+	return nil
+}
+
+func (w *WALBacklogStore) Append(putData PutData) error {
+	body, err := ObjToByte(putData)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.data[putData.Id]; ok {
+		return nil
+	}
+	if err = w.write(walRecord(walRecordAdd, putData.Id, body)); err != nil {
+		return err
+	}
+	w.data[putData.Id] = putData
+	return nil
+}
+
+func (w *WALBacklogStore) Delete(putId int64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.data[putId]; !ok {
+		return nil
+	}
+	if err := w.write(walRecord(walRecordDel, putId, nil)); err != nil {
+		return err
+	}
+	delete(w.data, putId)
+	w.dead += 2
+	if w.dead >= walCompactMin && w.dead > int64(len(w.data)) {
+		// 压缩失败时删除记录已写入，日志仍可继续使用
+		if err := w.compact(); err != nil {
+			Error("积压日志压缩失败 err = ", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// Range 按putId顺序遍历积压数据
+func (w *WALBacklogStore) Range(fn func(putData PutData) bool) error {
+	w.mu.Lock()
+	list := make([]PutData, 0, len(w.data))
+	for _, v := range w.data {
+		list = append(list, v)
+	}
+	w.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Id < list[j].Id
+	})
+	for _, v := range list {
+		if !fn(v) {
+			break
+		}
+	}
+	return nil
+}
+
+func (w *WALBacklogStore) Len() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return int64(len(w.data))
+}
+
+func (w *WALBacklogStore) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Sync()
+}
+
+func (w *WALBacklogStore) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	return w.file.Close()
+}
+
+// compact 只保留有效的数据重写日志，写入临时文件后替换
+// 临时文件的句柄在替换后直接用于追加，替换前的任何失败都保留原日志与原句柄
+func (w *WALBacklogStore) compact() error {
+	tmpPath := w.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(tmp)
+	for putId, putData := range w.data {
+		body, bErr := ObjToByte(putData)
+		if bErr != nil {
+			err = bErr
+			break
+		}
+		if _, err = writer.Write(walRecord(walRecordAdd, putId, body)); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, w.path)
+	}
+	if err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	_ = w.file.Close()
+	w.file = tmp
+	w.dead = 0
+	// 替换已生效，目录落盘保证崩溃后仍是新的日志
+	return syncDir(filepath.Dir(w.path))
+}
+
+// syncDir fsync目录，使目录中文件的创建与重命名落盘
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cErr := d.Close(); err == nil {
+		err = cErr
+	}
+	return err
+}
@@ -0,0 +1,228 @@
+package udp
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// walIds 回放后的积压数据id
+func walIds(t *testing.T, w *WALBacklogStore) []int64 {
+	t.Helper()
+	ids := make([]int64, 0)
+	_ = w.Range(func(putData PutData) bool {
+		if string(putData.Body) != "body" {
+			t.Fatalf("id:%d body = %s", putData.Id, putData.Body)
+		}
+		ids = append(ids, putData.Id)
+		return true
+	})
+	return ids
+}
+
+func equalIds(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// walOffsets 每条记录在日志中的起始位置，最后一个为文件长度
+func walOffsets(t *testing.T, path string) []int64 {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offsets := []int64{0}
+	for off := 0; off+walHeadLen <= len(b); {
+		off += walHeadLen + int(binary.BigEndian.Uint32(b[off+4:off+8]))
+		offsets = append(offsets, int64(off))
+	}
+	return offsets
+}
+
+func TestWALRecover(t *testing.T) {
+	cases := []struct {
+		name    string
+		corrupt func(path string, offsets []int64) error
+		want    []int64
+		size    int // 截断后保留的记录数
+	}{
+		{"truncate", func(path string, offsets []int64) error {
+			// 最后一条记录只写了一半
+			return os.Truncate(path, offsets[len(offsets)-1]-3)
+		}, []int64{1, 3, 4, 5}, 6},
+		{"crc", func(path string, offsets []int64) error {
+			// 第4条记录(add 4)的校验和损坏，之后的记录都被丢弃
+			f, err := os.OpenFile(path, os.O_RDWR, 0644)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			b := make([]byte, 1)
+			if _, err = f.ReadAt(b, offsets[3]); err != nil {
+				return err
+			}
+			_, err = f.WriteAt([]byte{b[0] ^ 0xff}, offsets[3])
+			return err
+		}, []int64{1, 2, 3}, 3},
+	}
+	for _, v := range cases {
+		dir := t.TempDir()
+		path := filepath.Join(dir, walFileName)
+		w, err := NewWALBacklogStore(dir, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for id := int64(1); id <= 5; id++ {
+			if err := w.Append(PutData{Label: "label", Id: id, Body: []byte("body")}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Delete(2); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Append(PutData{Label: "label", Id: 6, Body: []byte("body")}); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		offsets := walOffsets(t, path)
+		if len(offsets) != 8 {
+			t.Fatalf("%s: 记录数 %d", v.name, len(offsets)-1)
+		}
+		if err := v.corrupt(path, offsets); err != nil {
+			t.Fatal(err)
+		}
+
+		w, err = NewWALBacklogStore(dir, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ids := walIds(t, w); !equalIds(ids, v.want) {
+			t.Fatalf("%s: 回放结果 %v, 应为 %v", v.name, ids, v.want)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != offsets[v.size] {
+			t.Fatalf("%s: 截断后 %d 个字节, 应为 %d", v.name, info.Size(), offsets[v.size])
+		}
+		// 截断后继续追加，再次回放的结果一致
+		if err := w.Append(PutData{Label: "label", Id: 7, Body: []byte("body")}); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		w, err = NewWALBacklogStore(dir, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ids := walIds(t, w); !equalIds(ids, append(v.want, 7)) {
+			t.Fatalf("%s: 再次回放 %v", v.name, ids)
+		}
+		_ = w.Close()
+	}
+}
+
+func TestWALCompactReopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, walFileName)
+	w, err := NewWALBacklogStore(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const total, deleted = 600, 550
+	for id := int64(1); id <= total; id++ {
+		if err := w.Append(PutData{Label: "label", Id: id, Body: []byte("body")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before := walOffsets(t, path)
+	for id := int64(1); id <= deleted; id++ {
+		if err := w.Delete(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 压缩后追加的记录在新文件中
+	if err := w.Append(PutData{Label: "label", Id: total + 1, Body: []byte("body")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	after := walOffsets(t, path)
+	if len(after) >= len(before) {
+		t.Fatalf("日志未压缩 压缩前 %d 条记录, 压缩后 %d 条", len(before)-1, len(after)-1)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("临时文件未清理 err = %v", err)
+	}
+
+	w, err = NewWALBacklogStore(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	want := make([]int64, 0, total-deleted+1)
+	for id := int64(deleted + 1); id <= total+1; id++ {
+		want = append(want, id)
+	}
+	if ids := walIds(t, w); !equalIds(ids, want) {
+		t.Fatalf("重新打开后 %d 条, 应为 %d 条", len(ids), len(want))
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() != after[len(after)-1] {
+		t.Fatalf("重新打开后日志被截断 err = %v", err)
+	}
+}
+
+// TestWALCompactFail 压缩失败时返回错误，日志仍可继续写入并回放
+func TestWALCompactFail(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWALBacklogStore(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 临时文件的路径被目录占用，无法创建
+	if err := os.Mkdir(filepath.Join(dir, walFileName+".tmp"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	const total = walCompactMin
+	for id := int64(1); id <= total; id++ {
+		if err := w.Append(PutData{Label: "label", Id: id, Body: []byte("body")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var compactErr error
+	for id := int64(1); id < total && compactErr == nil; id++ {
+		compactErr = w.Delete(id)
+	}
+	if compactErr == nil {
+		t.Fatal("压缩失败时 Delete 应返回错误")
+	}
+	if err := w.Append(PutData{Label: "label", Id: total + 1, Body: []byte("body")}); err != nil {
+		t.Fatalf("压缩失败后追加 err = %v", err)
+	}
+	want := walIds(t, w)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	w, err = NewWALBacklogStore(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if ids := walIds(t, w); !equalIds(ids, want) {
+		t.Fatalf("回放 %v, 应为 %v", ids, want)
+	}
+}
@@ -0,0 +1,220 @@
+package udp
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// TransportMode servers收发数据包的方式
+type TransportMode int
+
+const (
+	TransportStd   TransportMode = iota // 每个包一次系统调用，默认
+	TransportBatch                      // Linux 使用 recvmmsg/sendmmsg 批量收发，其他系统退化为逐个收发
+)
+
+// batchConn ipv4.PacketConn 与 ipv6.PacketConn 的批量收发
+type batchConn interface {
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+	WriteBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+// isIPv6Conn socket是否为IPv6(含双栈)
+func isIPv6Conn(conn *net.UDPConn) bool {
+	addr, ok := conn.LocalAddr().(*net.UDPAddr)
+	return ok && addr.IP.To4() == nil
+}
+
+func newBatchConn(conn *net.UDPConn) batchConn {
+	if isIPv6Conn(conn) {
+		return ipv6.NewPacketConn(conn)
+	}
+	return ipv4.NewPacketConn(conn)
+}
+
+// readLoopBatch 一个socket的批量读取循环，一次系统调用读取多个包，Shutdown 后返回nil，连接被外部关闭时返回错误
+func (s *Servers) readLoopBatch(conn *net.UDPConn) error {
+	bc := newBatchConn(conn)
+	msgs := make([]ipv4.Message, s.batchSize)
+	bufs := make([]*[]byte, s.batchSize)
+	for i := range msgs {
+		bufs[i] = packetBufPool.Get().(*[]byte)
+		msgs[i].Buffers = [][]byte{*bufs[i]}
+	}
+	defer func() {
+		for _, buf := range bufs {
+			packetBufPool.Put(buf)
+		}
+	}()
+	for {
+		n, err := bc.ReadBatch(msgs, 0)
+		if err != nil {
+			if s.isClosed() {
+				return nil
+			}
+			Error(err)
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			continue
+		}
+		for i := 0; i < n; i++ {
+			addr, ok := msgs[i].Addr.(*net.UDPAddr)
+			if !ok {
+				continue
+			}
+			s.pool.submit(&inPacket{buf: bufs[i], n: msgs[i].N, addr: addr, conn: conn})
+			// 缓冲区交给了处理协程，换一个新的
+			bufs[i] = packetBufPool.Get().(*[]byte)
+			msgs[i].Buffers[0] = *bufs[i]
+		}
+	}
+}
+
+type outPacket struct {
+	data []byte
+	addr net.Addr
+}
+
+// batchWriter 一个socket的批量发送，取出队列中已有的包一次系统调用发出，linger 大于0时等待凑满一批或 linger 超时
+type batchWriter struct {
+	conn   *net.UDPConn
+	bc     batchConn
+	v6     bool
+	size   int
+	linger time.Duration
+	timer  *time.Timer
+	queue  chan outPacket
+	msgs   []ipv4.Message
+	sent   uint64 // 批量发出的包数
+	calls  uint64 // 批量发送的系统调用数
+}
+
+func newBatchWriter(conn *net.UDPConn, size int, linger time.Duration) *batchWriter {
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	return &batchWriter{
+		conn:   conn,
+		bc:     newBatchConn(conn),
+		v6:     isIPv6Conn(conn),
+		size:   size,
+		linger: linger,
+		timer:  timer,
+		queue:  make(chan outPacket, size*4),
+		msgs:   make([]ipv4.Message, 0, size),
+	}
+}
+
+// write 放入发送队列，Shutdown 后直接发送
+func (w *batchWriter) write(done <-chan struct{}, addr net.Addr, data []byte) {
+	select {
+	case <-done:
+		w.writeTo(addr, data)
+		return
+	default:
+	}
+	select {
+	case w.queue <- outPacket{data: data, addr: addr}:
+	case <-done:
+		w.writeTo(addr, data)
+	}
+}
+
+func (w *batchWriter) writeTo(addr net.Addr, data []byte) {
+	if _, err := w.conn.WriteTo(data, addr); err != nil {
+		Error(err.Error())
+	}
+}
+
+// run 发送协程，Shutdown 时发送完队列中剩余的包后退出
+func (w *batchWriter) run(done <-chan struct{}) {
+	for {
+		select {
+		case p := <-w.queue:
+			w.add(p)
+		case <-done:
+			for {
+				select {
+				case p := <-w.queue:
+					w.add(p)
+					if len(w.msgs) >= w.size {
+						w.flush()
+					}
+				default:
+					w.flush()
+					return
+				}
+			}
+		}
+		w.collect(done)
+		w.flush()
+	}
+}
+
+// collect 取出队列中等待发送的包，linger 大于0且不足一批时继续等待到 linger 超时
+// 负载不高时队列经常为空，不等待时每次系统调用只发出一个包，等待则增加每个包的延迟
+func (w *batchWriter) collect(done <-chan struct{}) {
+drain:
+	for len(w.msgs) < w.size {
+		select {
+		case p := <-w.queue:
+			w.add(p)
+		default:
+			break drain
+		}
+	}
+	if len(w.msgs) >= w.size || w.linger <= 0 {
+		return
+	}
+	w.timer.Reset(w.linger)
+	defer func() {
+		if !w.timer.Stop() {
+			select {
+			case <-w.timer.C:
+			default:
+			}
+		}
+	}()
+	for len(w.msgs) < w.size {
+		select {
+		case p := <-w.queue:
+			w.add(p)
+		case <-w.timer.C:
+			return
+		case <-done:
+			return
+		}
+	}
+}
+
+func (w *batchWriter) add(p outPacket) {
+	if ua, ok := p.addr.(*net.UDPAddr); w.v6 && ok && ua.IP.To4() != nil {
+		// 双栈socket的批量发送不支持IPv4地址，逐个发送
+		w.writeTo(p.addr, p.data)
+		return
+	}
+	w.msgs = append(w.msgs, ipv4.Message{Buffers: [][]byte{p.data}, Addr: p.addr})
+}
+
+func (w *batchWriter) flush() {
+	msgs := w.msgs
+	for len(msgs) > 0 {
+		n, err := w.bc.WriteBatch(msgs, 0)
+		if err != nil {
+			Error(err.Error())
+			break
+		}
+		atomic.AddUint64(&w.sent, uint64(n))
+		atomic.AddUint64(&w.calls, 1)
+		msgs = msgs[n:]
+	}
+	for i := range w.msgs {
+		w.msgs[i] = ipv4.Message{}
+	}
+	w.msgs = w.msgs[:0]
+}
@@ -0,0 +1,216 @@
+package udp
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func init() {
+	CloseLog()
+}
+
+// sinks 开启n个只接收的udp socket，模拟c端
+func sinks(b *testing.B, n int) ([]*net.UDPAddr, *uint64, func()) {
+	var (
+		received uint64
+		wg       sync.WaitGroup
+		addrs    = make([]*net.UDPAddr, 0, n)
+		conns    = make([]*net.UDPConn, 0, n)
+	)
+	for i := 0; i < n; i++ {
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			b.Fatal(err)
+		}
+		_ = conn.SetReadBuffer(4 << 20)
+		conns = append(conns, conn)
+		addrs = append(addrs, conn.LocalAddr().(*net.UDPAddr))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, packetBufSize)
+			for {
+				if _, _, err := conn.ReadFromUDP(buf); err != nil {
+					return
+				}
+				atomic.AddUint64(&received, 1)
+			}
+		}()
+	}
+	return addrs, &received, func() {
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+		wg.Wait()
+	}
+}
+
+func benchServers(b *testing.B, transport TransportMode, linger time.Duration) *Servers {
+	s, err := NewServers("127.0.0.1", 0, ServersConf{
+		Name:        DefaultServersName,
+		ConnectCode: DefaultConnectCode,
+		SecretKey:   DefaultSecretKey,
+		Transport:   transport,
+		BatchLinger: linger,
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	conn := s.Conn.(*net.UDPConn)
+	_ = conn.SetWriteBuffer(4 << 20)
+	_ = conn.SetReadBuffer(4 << 20)
+	return s
+}
+
+// reportBatch 每次批量发送的系统调用平均发出的包数
+func reportBatch(b *testing.B, s *Servers) {
+	if w, ok := s.writers[s.Conn]; ok {
+		if calls := atomic.LoadUint64(&w.calls); calls > 0 {
+			b.ReportMetric(float64(atomic.LoadUint64(&w.sent))/float64(calls), "pkts/call")
+		}
+	}
+}
+
+var benchTransports = []struct {
+	name      string
+	transport TransportMode
+	linger    time.Duration
+}{
+	{"std", TransportStd, 0},
+	{"batch", TransportBatch, 0},
+	{"batch-linger", TransportBatch, 200 * time.Microsecond},
+}
+
+// BenchmarkServersWrite 向多个c端发送数据包，模拟 NoticeAll
+func BenchmarkServersWrite(b *testing.B) {
+	for _, bc := range benchTransports {
+		b.Run(bc.name, func(b *testing.B) {
+			s := benchServers(b, bc.transport, bc.linger)
+			addrs, received, closeSinks := sinks(b, 64)
+			data, err := packetEncoder(CommandNotice, s.name, createSign(), []byte("benchmark notice"), &packetConf{secret: s.secretKey})
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			start := time.Now()
+			for i := 0; i < b.N; i++ {
+				s.Write(addrs[i%len(addrs)], data)
+			}
+			if w, ok := s.writers[s.Conn]; ok {
+				// 等待发送队列清空
+				for len(w.queue) > 0 {
+					time.Sleep(time.Millisecond)
+				}
+			}
+			elapsed := time.Since(start)
+			b.StopTimer()
+			b.ReportMetric(float64(b.N)/elapsed.Seconds(), "pkts/s")
+			reportBatch(b, s)
+			time.Sleep(10 * time.Millisecond)
+			b.ReportMetric(float64(atomic.LoadUint64(received))/float64(b.N)*100, "%delivered")
+			_ = s.Shutdown(context.Background())
+			closeSinks()
+		})
+	}
+}
+
+// BenchmarkServersReply 多个处理协程各自处理请求、封包并应答，负载不高时发送队列经常为空
+func BenchmarkServersReply(b *testing.B) {
+	for _, bc := range benchTransports {
+		b.Run(bc.name, func(b *testing.B) {
+			s := benchServers(b, bc.transport, bc.linger)
+			addrs, received, closeSinks := sinks(b, 64)
+			conf := &packetConf{secret: s.secretKey, cipher: CipherAESGCM, version: PacketV1, compressMin: DefaultCompressMin}
+			workers := 16
+			var wg sync.WaitGroup
+			b.ResetTimer()
+			start := time.Now()
+			for i := 0; i < workers; i++ {
+				wg.Add(1)
+				go func(i, n int) {
+					defer wg.Done()
+					for j := 0; j < n; j++ {
+						// 处理请求的耗时
+						time.Sleep(50 * time.Microsecond)
+						data, err := packetEncoder(CommandReply, s.name, createSign(), []byte("benchmark reply"), conf)
+						if err != nil {
+							b.Error(err)
+							return
+						}
+						s.Write(addrs[(i+j*workers)%len(addrs)], data)
+					}
+				}(i, b.N/workers)
+			}
+			wg.Wait()
+			if w, ok := s.writers[s.Conn]; ok {
+				for len(w.queue) > 0 {
+					time.Sleep(time.Millisecond)
+				}
+			}
+			elapsed := time.Since(start)
+			b.StopTimer()
+			b.ReportMetric(float64(b.N)/elapsed.Seconds(), "pkts/s")
+			reportBatch(b, s)
+			time.Sleep(10 * time.Millisecond)
+			b.ReportMetric(float64(atomic.LoadUint64(received))/float64(b.N)*100, "%delivered")
+			_ = s.Shutdown(context.Background())
+			closeSinks()
+		})
+	}
+}
+
+// BenchmarkServersRead 多个c端向servers发送数据包，统计servers的收包速度
+func BenchmarkServersRead(b *testing.B) {
+	for _, bc := range benchTransports {
+		b.Run(bc.name, func(b *testing.B) {
+			s := benchServers(b, bc.transport, bc.linger)
+			go func() {
+				_ = s.Run()
+			}()
+			// 未到齐的分片包，servers解包后不再应答
+			f := &Fragment{Command: CommandPut, Id: id(), Index: 0, Total: 2, Data: []byte("benchmark")}
+			data, err := packetEncoder(CommandFragment, DefaultClientName, createSign(), f.encode(), &packetConf{secret: s.secretKey})
+			if err != nil {
+				b.Fatal(err)
+			}
+			senders := 8
+			var wg sync.WaitGroup
+			b.ResetTimer()
+			start := time.Now()
+			for i := 0; i < senders; i++ {
+				wg.Add(1)
+				go func(n int) {
+					defer wg.Done()
+					conn, err := net.DialUDP("udp4", nil, s.Conn.LocalAddr().(*net.UDPAddr))
+					if err != nil {
+						b.Error(err)
+						return
+					}
+					defer conn.Close()
+					for j := 0; j < n; j++ {
+						_, _ = conn.Write(data)
+					}
+				}(b.N / senders)
+			}
+			wg.Wait()
+			// 等待servers读取完内核缓冲区中的包
+			last := s.Stats().Received
+			for {
+				time.Sleep(5 * time.Millisecond)
+				if cur := s.Stats().Received; cur == last {
+					break
+				} else {
+					last = cur
+				}
+			}
+			elapsed := time.Since(start)
+			b.StopTimer()
+			b.ReportMetric(float64(last)/elapsed.Seconds(), "pkts/s")
+			_ = s.Shutdown(context.Background())
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package udp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"sync"
+)
+
+// CipherSuite 数据包加密套件，占用指令字节的高2位，接收端根据包头选择解密方式
+type CipherSuite uint8
+
+const (
+	CipherDES    CipherSuite = 0x0 // DES ECB 旧版本的加密方式，默认值，保证旧版本的两端可以通讯
+	CipherAESGCM CipherSuite = 0x1 // AES-256-GCM 带完整性校验，每个包使用随机nonce；8个字节的秘钥派生后强度仍只有64位，需要256位强度时使用32个字节的秘钥
+	CipherNone   CipherSuite = 0x2 // 不加密
+)
+
+const (
+	cipherShift = 6
+	commandMask = 0x3f
+	desKeyLen   = 8  // DES 秘钥长度，AES-GCM 使用8个字节的秘钥时通过HKDF派生
+	aesKeyLen   = 32 // AES-256 秘钥长度，只用于 CipherAESGCM
+)
+
+// aesKeySalt aesKeyInfo 从8个字节的秘钥派生AES-256秘钥时HKDF使用的协议盐值与用途，修改后两端无法互通
+var (
+	aesKeySalt = []byte("udp_comm packet key salt v1")
+	aesKeyInfo = []byte("udp_comm aes-256-gcm")
+)
+
+func (c CipherSuite) String() string {
+	switch c {
+	case CipherDES:
+		return "des-ecb"
+	case CipherAESGCM:
+		return "aes-256-gcm"
+	case CipherNone:
+		return "none"
+	}
+	return "unknown"
+}
+
+// checkSecretKey 秘钥为8个字节，使用 CipherAESGCM 时也可以为32个字节(直接作为AES-256的秘钥)
+func checkSecretKey(key string, cipher CipherSuite) bool {
+	return len(key) == desKeyLen || (len(key) == aesKeyLen && cipher == CipherAESGCM)
+}
+
+// encryptedLen n个字节的数据加密后的长度，分片前用于估算，不需要实际加密
+func encryptedLen(suite CipherSuite, n int) int {
+	switch suite {
+	case CipherDES:
+		// pkcs5 补齐 1~8 个字节
+		return n + 8 - n%8
+	case CipherAESGCM:
+		// nonce + tag
+		return n + 12 + 16
+	}
+	return n
+}
+
+// aeadCache 缓存 秘钥 -> cipher.AEAD, AEAD 可以并发使用
+var aeadCache sync.Map
+
+func newAesGCM(key []byte) (cipher.AEAD, error) {
+	if v, ok := aeadCache.Load(string(key)); ok {
+		return v.(cipher.AEAD), nil
+	}
+	k := key
+	if len(key) != aesKeyLen {
+		// 秘钥长度与DES统一为8，派生出AES-256的秘钥，派生不增加秘钥的熵，强度仍为64位
+		k = hkdfSha256(key, aesKeySalt, aesKeyInfo)
+	}
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	aeadCache.Store(string(key), aead)
+	return aead, nil
+}
+
+// hkdfSha256 HKDF-SHA256(RFC 5869) 派生32个字节的秘钥，只需要一个块: T(1) = HMAC(PRK, info | 0x01)
+func hkdfSha256(secret, salt, info []byte) []byte {
+	extract := hmac.New(sha256.New, salt)
+	_, _ = extract.Write(secret)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	_, _ = expand.Write(info)
+	_, _ = expand.Write([]byte{1})
+	return expand.Sum(nil)
+}
+
+// AesGCMEncrypt AES-256-GCM 加密，返回 nonce+密文，additional 为参与校验但不加密的数据(包头)
+func AesGCMEncrypt(data, key, additional []byte) ([]byte, error) {
+	aead, err := newAesGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, data, additional), nil
+}
+
+// AesGCMDecrypt AES-256-GCM 解密，数据或包头被篡改时返回 ErrPacketAuth
+func AesGCMDecrypt(data, key, additional []byte) ([]byte, error) {
+	aead, err := newAesGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize()+aead.Overhead() {
+		return nil, ErrPacketAuth
+	}
+	nonce := data[:aead.NonceSize()]
+	out, err := aead.Open(nil, nonce, data[aead.NonceSize():], additional)
+	if err != nil {
+		return nil, ErrPacketAuth
+	}
+	return out, nil
+}
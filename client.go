@@ -1,33 +1,107 @@
 package udp
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"net"
 	"os"
 	"os/signal"
-	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
 type Client struct {
-	ServersHost  string           // serversIP:port
-	Conn         *net.UDPConn     // 连接对象
-	SConn        *net.UDPAddr     // s端连接信息
-	name         string           // client的名称
-	connectCode  string           // 连接code 是静态的由server端配发
-	state        int              // 0:未连接   1:连接成功  2:server端丢失
-	sign         string           // 签名
-	secretKey    string           // 数据传输加密解密秘钥
-	GetHandle    ClientGetFunc    // get方法
-	NoticeHandle ClientNoticeFunc // 接收通知的方法
+	ServersHost      string         // 当前servers serversIP:port
+	Conn             net.PacketConn // 连接对象，默认为UDP
+	SConn            net.Addr       // 当前servers的连接信息
+	hosts            []string       // 有序的servers地址列表 host:port
+	servers          []net.Addr     // hosts解析后的地址，第一个为首选
+	resolve          bool           // 重连时是否重新解析hosts, NewClientWithConn 传入的地址不解析
+	current          int            // 当前servers在列表中的序号
+	serverLock       sync.RWMutex
+	failback         time.Duration // 探测首选servers的间隔，小于等于0不回切
+	lastProbe        time.Time     // 最后一次探测首选servers的时间
+	name             string        // client的名称
+	connectCode      string        // 连接code 是静态的由server端配发
+	state            int32         // 连接状态 ClientState
+	missed           int32         // 已发送但未收到应答的心跳数
+	heartbeat        time.Duration // 心跳间隔
+	heartbeatMaxMiss int           // 连续未应答的心跳数达到该值判定servers丢失
+	hook             clientHook    // 回调
+	signLock         sync.RWMutex
+	sign             string                  // 签名
+	prevSign         string                  // 上一个签名，应答乱序到达时仍然有效
+	secretKey        string                  // 数据传输加密解密秘钥
+	cipher           CipherSuite             // 数据传输加密套件
+	strict           bool                    // 不接受DES加密的数据包
+	version          uint8                   // 封包使用的包头版本
+	codec            CodecType               // 信封结构的编码
+	compress         CompressType            // data的压缩算法
+	compressMin      int                     // 小于该字节数的数据不压缩
+	GetHandle        ClientGetFunc           // get方法
+	NoticeHandle     ClientNoticeFunc        // 接收通知的方法
+	fragment         *fragmentPool           // 分片重组池
+	backlog          BacklogStore            // 积压的数据
+	backlogDir       string                  // 使用的默认积压目录，Shutdown 后释放
+	putWait          sync.Map                // 等待服务端确认的put  putId -> chan error
+	done             chan struct{}           // Shutdown时关闭，通知心跳退出
+	closed           int32                   // 1:已关闭
+	runLock          sync.Mutex              // Run 启动协程与 Shutdown 互斥，Shutdown 之后不再 wg.Add
+	wg               sync.WaitGroup          // 读取循环，心跳与处理中的请求
+	handleSignals    bool                    // Run时是否监听退出信号
+	reconnectPolicy  ReconnectPolicy         // 重连策略
+	reconnecting     int32                   // 1:重连中
+	connectWait      map[chan error]struct{} // 等待连接结果的 Connect
+	connectLock      sync.Mutex
+	pool             *workerPool // 处理收到的数据包
 }
 
 type ClientConf struct {
 	Name        string
 	ConnectCode string
-	SecretKey   string // 数据传输加密解密秘钥
+	SecretKey   string      // 数据传输加密解密秘钥 8个字节，Cipher 为 CipherAESGCM 时也可以为32个字节(8个字节时AES的强度只有64位)
+	Cipher      CipherSuite // 数据传输加密套件 默认DES, 与servers端统一
+	BacklogMax  int64       // 内存中最大积压数据包条数，超过后持久化到磁盘 默认10000
+	BacklogMin  int64       // 积压数据小于该值时才加载持久化数据 默认 BacklogMax/2
+	BacklogDir  string      // 积压数据持久化的目录 默认 ./udb/<name>@<首选servers>，同一进程内重复时加序号
+
+	// BacklogStore 积压数据的存储，为空时使用 UdbBacklogStore(BacklogMax, BacklogMin, BacklogDir)
+	BacklogStore BacklogStore
+
+	// StrictCipher 只接受 Cipher 配置的加密套件，不接受DES加密的数据包 默认false
+	StrictCipher bool
+
+	// HandleSignals Run时监听退出信号，收到后持久化积压数据并退出进程，默认不监听
+	HandleSignals bool
+
+	// LegacyHeader 使用v0包头封包，与只支持v0包头的旧版本servers通讯时设置，默认使用v1包头
+	LegacyHeader bool
+	// Codec 信封结构的编码 默认CodecBinary，LegacyHeader 时总是使用 CodecJSON
+	Codec CodecType
+	// Compress data的压缩算法 默认CompressZlib，LegacyHeader 时总是使用zlib
+	Compress CompressType
+	// CompressMin 小于该字节数的数据不压缩 默认64，小于0时总是压缩
+	CompressMin int
+
+	// Heartbeat 心跳间隔 默认5s, 应小于servers端判定离线的时间(6s)
+	Heartbeat time.Duration
+	// HeartbeatMaxMiss 连续未应答的心跳数达到该值判定servers丢失 默认3
+	HeartbeatMaxMiss int
+
+	// Reconnect 判定servers丢失后的重连策略，未设置的字段使用 DefaultReconnectPolicy
+	Reconnect ReconnectPolicy
+
+	// Servers 有序的servers地址列表 ip:port，第一个为首选，设置后忽略 NewClient 的 host
+	Servers []string
+	// Failback 切换到备用servers后探测首选servers的间隔 默认30s，小于0不回切
+	Failback time.Duration
+
+	Workers   int            // 处理数据包的协程数 默认64
+	QueueSize int            // 等待处理的数据包队列长度 默认1024
+	Overflow  OverflowPolicy // 队列满时的策略 默认丢弃
 }
 
 func SetClientConf(clientName, connectCode, secretKey string) ClientConf {
@@ -39,12 +113,77 @@ func SetClientConf(clientName, connectCode, secretKey string) ClientConf {
 }
 
 func NewClient(host string, conf ...ClientConf) (*Client, error) {
+	hosts := []string{host}
+	if len(conf) >= 1 && len(conf[0].Servers) > 0 {
+		hosts = conf[0].Servers
+	}
+	c, err := newClient(hosts[0], conf...)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range hosts {
+		addr, err := resolveServersHost(v)
+		if err != nil {
+			releaseBacklogDir(c.backlogDir)
+			return nil, err
+		}
+		c.hosts = append(c.hosts, v)
+		c.servers = append(c.servers, addr)
+	}
+	c.resolve = true
+	c.SConn = c.servers[0]
+	c.ServersHost = c.hosts[0]
+	// 监听所有地址(双栈)，可以同时连接IPv4与IPv6的servers
+	c.Conn, err = net.ListenUDP("udp", &net.UDPAddr{})
+	if err != nil {
+		releaseBacklogDir(c.backlogDir)
+		return nil, err
+	}
+	// 连接服务器
+	c.ConnectServers()
+	return c, nil
+}
+
+// NewClientWithConn 使用已创建的连接，可以是任意 net.PacketConn，如unix datagram socket、内存管道或包装了统计的连接
+// servers 为有序的servers地址列表，第一个为首选，ClientConf.Servers 不生效
+func NewClientWithConn(conn net.PacketConn, servers []net.Addr, conf ...ClientConf) (*Client, error) {
+	if len(servers) < 1 {
+		return nil, ErrNoneServers
+	}
+	c, err := newClient(servers[0].String(), conf...)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range servers {
+		c.hosts = append(c.hosts, v.String())
+		c.servers = append(c.servers, v)
+	}
+	c.SConn = c.servers[0]
+	c.ServersHost = c.hosts[0]
+	c.Conn = conn
+	// 连接服务器
+	c.ConnectServers()
+	return c, nil
+}
+
+// newClient server 为首选servers的地址，用于默认的积压数据目录
+func newClient(server string, conf ...ClientConf) (*Client, error) {
 	c := &Client{
-		ServersHost:  host,
-		state:        0,
-		GetHandle:    make(ClientGetFunc),
-		NoticeHandle: make(ClientNoticeFunc),
+		state:            int32(StateConnecting),
+		heartbeat:        HeartbeatTime * time.Second,
+		heartbeatMaxMiss: HeartbeatMaxMiss,
+		GetHandle:        make(ClientGetFunc),
+		NoticeHandle:     make(ClientNoticeFunc),
+		fragment:         newFragmentPool(),
+		version:          PacketV1,
+		compressMin:      DefaultCompressMin,
+		done:             make(chan struct{}),
+		failback:         DefaultFailbackTime * time.Second,
 	}
+	var (
+		workers, queueSize int
+		overflow           OverflowPolicy
+	)
 	if len(conf) >= 1 {
 		if len(conf[0].ConnectCode) > 0 {
 			c.connectCode = conf[0].ConnectCode
@@ -52,47 +191,81 @@ func NewClient(host string, conf ...ClientConf) (*Client, error) {
 		if strings.IndexAny(conf[0].Name, "@") != -1 {
 			return nil, ErrClientNameErr
 		}
-		if len(conf[0].Name) > 7 {
+		if err := checkName(conf[0].Name); err != nil {
+			return nil, err
+		}
+		if conf[0].LegacyHeader && len(conf[0].Name) > packetV0NameLen {
 			return nil, ErrNmeLengthAbove
 		}
-		if len(conf[0].Name) > 0 && len(conf[0].Name) <= 7 {
+		if len(conf[0].Name) > 0 {
 			c.name = conf[0].Name
 		}
-		if len(conf[0].SecretKey) != 8 {
-			return nil, fmt.Errorf("秘钥的长度只能为8，并且与Servers端统一")
-		} else if len(conf[0].SecretKey) == 0 {
-			c.secretKey = DefaultSecretKey
+		if !checkSecretKey(conf[0].SecretKey, conf[0].Cipher) {
+			return nil, ErrClientSecretKey
 		} else {
 			c.secretKey = conf[0].SecretKey
 		}
+		c.cipher = conf[0].Cipher
+		c.strict = conf[0].StrictCipher
+		if _, err := getCodec(conf[0].Codec); err != nil {
+			return nil, err
+		}
+		c.codec = conf[0].Codec
+		if _, err := getCompressor(conf[0].Compress); err != nil {
+			return nil, err
+		}
+		c.compress = conf[0].Compress
+		c.compressMin = compressMin(conf[0].CompressMin)
+		if conf[0].LegacyHeader {
+			c.version = PacketV0
+			c.codec = CodecJSON
+		}
+		c.handleSignals = conf[0].HandleSignals
+		if conf[0].Heartbeat > 0 {
+			c.heartbeat = conf[0].Heartbeat
+		}
+		if conf[0].HeartbeatMaxMiss > 0 {
+			c.heartbeatMaxMiss = conf[0].HeartbeatMaxMiss
+		}
+		c.reconnectPolicy = conf[0].Reconnect.normalize()
+		if conf[0].Failback != 0 {
+			c.failback = conf[0].Failback
+		}
+		workers, queueSize, overflow = conf[0].Workers, conf[0].QueueSize, conf[0].Overflow
+		c.backlog = conf[0].BacklogStore
+		if c.backlog == nil {
+			dir := conf[0].BacklogDir
+			if len(dir) < 1 {
+				c.backlogDir = defaultBacklogDir(c.name, server)
+				dir = c.backlogDir
+			}
+			c.backlog = NewUdbBacklogStore(conf[0].BacklogMax, conf[0].BacklogMin, dir)
+		}
 	} else {
 		c.DefaultClientName()
 		c.DefaultConnectCode()
 		c.DefaultSecretKey()
+		c.backlogDir = defaultBacklogDir(c.name, server)
+		c.backlog = NewUdbBacklogStore(DefaultBacklogMax, DefaultBacklogMin, c.backlogDir)
+		c.reconnectPolicy = DefaultReconnectPolicy()
 	}
-	sHost := strings.Split(c.ServersHost, ":")
-	sip := net.ParseIP(sHost[0])
-	sport, err := strconv.Atoi(sHost[1])
-	srcAddr := &net.UDPAddr{IP: net.IPv4zero, Port: 0}
-	dstAddr := &net.UDPAddr{IP: sip, Port: sport}
-	c.Conn, err = net.DialUDP("udp", srcAddr, dstAddr)
-	if err != nil {
-		Error(err)
-	}
-	// 连接服务器
-	c.ConnectServers()
+	c.pool = newWorkerPool(workers, queueSize, overflow, c.process)
 	return c, nil
 }
 
+// SetClientName 名称最多64个字节，使用v0包头时最多7个字节
 func (c *Client) SetClientName(name string) error {
 	if strings.IndexAny(name, "@") != -1 {
 		return ErrClientNameErr
 	}
-	if len(name) > 0 && len(name) <= 7 {
-		c.name = name
-		return nil
+	if len(name) == 0 || (c.version == PacketV0 && len(name) > packetV0NameLen) {
+		return ErrNmeLengthAbove
+	}
+	if err := checkName(name); err != nil {
+		return err
 	}
-	return ErrNmeLengthAbove
+	c.name = name
+	return nil
 }
 
 func (c *Client) SetConnectCode(code string) {
@@ -100,23 +273,128 @@ func (c *Client) SetConnectCode(code string) {
 }
 
 func (c *Client) SetSecretKey(key string) error {
-	if len(key) != 8 {
+	if !checkSecretKey(key, c.cipher) {
 		return ErrClientSecretKey
 	}
 	c.secretKey = key
 	return nil
 }
 
-func (c *Client) Run() {
+// SetCipher 秘钥为32个字节时只能使用 CipherAESGCM
+func (c *Client) SetCipher(cipher CipherSuite) error {
+	if !checkSecretKey(c.secretKey, cipher) {
+		return ErrClientSecretKey
+	}
+	c.cipher = cipher
+	return nil
+}
+
+// Run 启动client, 阻塞直到 Shutdown 被调用，返回 ErrClientClosed
+// 连接被外部关闭时返回读取的错误(net.ErrClosed)，仍需调用 Shutdown 停止心跳并持久化积压数据
+func (c *Client) Run() error {
+	c.runLock.Lock()
+	if c.isClosed() {
+		c.runLock.Unlock()
+		return ErrClientClosed
+	}
+	c.wg.Add(1)
+	defer c.wg.Done()
 	// 时间轮,心跳维护，动态刷新签名
 	c.timeWheel()
+	if c.handleSignals {
+		c.HandleSignals()
+	}
+	// 启动与servers进行交互，读取循环只负责收包，解包与处理交给固定数量的协程
+	c.pool.start(&c.wg)
+	c.runLock.Unlock()
+
+	defer c.pool.stop()
+	for {
+		buf := packetBufPool.Get().(*[]byte)
+		n, remoteAddr, err := c.Conn.ReadFrom(*buf)
+		if err != nil {
+			packetBufPool.Put(buf)
+			if c.isClosed() {
+				return ErrClientClosed
+			}
+			Error(err)
+			if errors.Is(err, net.ErrClosed) {
+				c.setState(StateDisconnected)
+				return err
+			}
+			// 连接有异常更新连接状态
+			if c.State() == StateConnected {
+				c.setState(StateDegraded)
+			}
+			continue
+		}
+		c.pool.submit(&inPacket{buf: buf, n: n, addr: remoteAddr})
+	}
+}
+
+// process 解包并处理一个收到的数据包，由处理协程调用
+func (c *Client) process(in *inPacket) {
+	from := c.serverIndex(in.addr)
+	if from < 0 {
+		ErrorF("丢弃非servers的数据包 addr:%s", in.addr)
+		return
+	}
+	// Info("解包....size = ", n)
+	head, err := packetHeader(in.data(), in.n)
+	if err != nil {
+		// 不是本协议的包或已损坏，与秘钥无关
+		in.release()
+		Error("错误的包 err:", err)
+		return
+	}
+	packet, err := packetDecode(head, in.data()[:in.n], c.packetConf())
+	in.release()
+	if err != nil {
+		Error("错误的包 err:", err)
+		if current, _ := c.currentServer(); from == current {
+			// 当前servers的包无法解密，秘钥或加密套件不一致
+			c.connectDone(ErrConnectSecret)
+		}
+		return
+	}
+	if current, _ := c.currentServer(); from != current && packet.Command != CommandReply {
+		// 非当前servers只接受连接应答
+		return
+	}
+	if packet.Command == CommandFragment {
+		packet, err = c.fragment.add(in.addr.String(), packet)
+		if err != nil {
+			Error("错误的分片包 err:", err)
+			return
+		}
+		if packet == nil { // 分片未到齐
+			return
+		}
+	}
+	c.handle(packet, from)
+}
+
+// Stats 收到的数据包与丢弃的数据包统计
+func (c *Client) Stats() Stats {
+	return c.pool.stats()
+}
+
+// HandleSignals 监听退出信号，收到信号后 Close(持久化积压数据) 并退出进程
+// 未指定信号时监听 SIGTERM, SIGINT, SIGHUP, SIGQUIT
+// 默认不监听，由应用自己决定何时调用 Close，适合有自己生命周期管理的服务
+func (c *Client) HandleSignals(sig ...os.Signal) {
+	if len(sig) < 1 {
+		sig = []os.Signal{syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP, syscall.SIGQUIT}
+	}
 	ch := make(chan os.Signal, 1)
-	signal.Notify(ch, syscall.SIGTERM, syscall.SIGINT, syscall.SIGKILL, syscall.SIGHUP, syscall.SIGQUIT)
+	signal.Notify(ch, sig...)
 	go func() {
+		defer signal.Stop(ch)
 		select {
+		case <-c.done:
 		case s := <-ch:
 			Info("Client退出....")
-			toUdb() // 将积压的数据持久化
+			c.Close()
 			if i, ok := s.(syscall.Signal); ok {
 				os.Exit(int(i))
 			} else {
@@ -124,188 +402,330 @@ func (c *Client) Run() {
 			}
 		}
 	}()
+}
 
-	// 启动与servers进行交互
-	data := make([]byte, 1024)
-	for {
-		n, remoteAddr, err := c.Conn.ReadFromUDP(data)
-		if err != nil {
-			Error(err)
-			c.state = 0 // 连接有异常更新连接状态
-			continue
+func (c *Client) isClosed() bool {
+	return atomic.LoadInt32(&c.closed) == 1
+}
+
+// Shutdown 优雅关闭: 停止接收数据包，等待处理中的请求完成，停止心跳，
+// 将积压数据通过 BacklogStore 持久化并关闭，最后关闭连接
+// ctx结束时不再等待处理中的请求，返回ctx的错误
+func (c *Client) Shutdown(ctx context.Context) error {
+	c.runLock.Lock()
+	if !atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		c.runLock.Unlock()
+		return ErrClientClosed
+	}
+	c.setState(StateClosed)
+	close(c.done)
+	c.runLock.Unlock()
+	// 使阻塞中的读取立即返回
+	_ = c.Conn.SetReadDeadline(time.Now())
+	wait := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(wait)
+	}()
+	var err error
+	select {
+	case <-wait:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if bErr := c.backlog.Close(); bErr != nil {
+		Error("积压数据持久化失败 err = ", bErr)
+		if err == nil {
+			err = bErr
 		}
-		c.SConn = remoteAddr
-		// Info("解包....size = ", n)
-		packet, err := PacketDecrypt(c.secretKey, data, n)
-		if err != nil {
-			Error("错误的包 err:", err)
-			continue
+	}
+	releaseBacklogDir(c.backlogDir)
+	if cErr := c.Conn.Close(); err == nil {
+		err = cErr
+	}
+	return err
+}
+
+// handle 处理servers列表中第from个servers发来的包
+func (c *Client) handle(packet *Packet, from int) {
+	switch packet.Command {
+	// 来自server端的通知消息
+	case CommandNotice:
+		notice := &NoticeData{}
+		bErr := decodeObj(packet.Codec, packet.Data, &notice)
+		if bErr != nil {
+			Error("返回的包解析失败， err = ", bErr)
 		}
+		// 异步应答这个通知，然后处理执行通知
 		go func() {
-			switch packet.Command {
-			// 来自server端的通知消息
-			case CommandNotice:
-				notice := &NoticeData{}
-				bErr := ByteToObj(packet.Data, &notice)
-				if bErr != nil {
-					Error("返回的包解析失败， err = ", err)
-				}
-				// 异步应答这个通知，然后处理执行通知
-				go func() {
-					notice.Response = []byte("ok")
-					b, e := ObjToByte(notice)
-					if e != nil {
-						Error("ObjToByte err = ", e)
-					}
-					pack, pErr := PacketEncoder(CommandNotice, c.name, c.sign, c.secretKey, b)
-					if pErr != nil {
-						Error(pErr)
-					}
-					c.Write(pack)
-				}()
-				if fn, ok := c.NoticeHandle[notice.Label]; ok {
-					fn(c, notice.Data)
-				}
+			notice.Response = []byte("ok")
+			b, e := encodeObj(c.codec, notice)
+			if e != nil {
+				Error("encode err = ", e)
+			}
+			c.send(CommandNotice, b)
+		}()
+		if fn, ok := c.NoticeHandle[notice.Label]; ok {
+			fn(c, notice.Data)
+		}
 
-			// 来自server端的get请求
-			case CommandGet:
-				if c.sign != packet.Sign {
-					Info("未知主机认证!")
-					return
-				}
-				getData := &GetData{}
-				bErr := ByteToObj(packet.Data, &getData)
-				if bErr != nil {
-					Error("解析put err :", bErr)
-				}
-				if fn, ok := c.GetHandle[getData.Label]; ok {
-					code, rse := fn(c, getData.Param)
-					getData.Response = rse
-					gb, gbErr := ObjToByte(getData)
-					if gbErr != nil {
-						Error("对象转字节错误...")
-					}
-					c.ReplyGet(getData.Id, code, gb)
-				}
+	// 来自server端的get请求
+	case CommandGet:
+		if !c.checkSign(packet.Sign) {
+			Info("未知主机认证!")
+			return
+		}
+		getData := &GetData{}
+		bErr := decodeObj(packet.Codec, packet.Data, &getData)
+		if bErr != nil {
+			Error("解析put err :", bErr)
+		}
+		if fn, ok := c.GetHandle[getData.Label]; ok {
+			ctx, cancel := getData.context()
+			code, rse := fn(withCodec(ctx, packet.Codec), c, getData.Param)
+			cancel()
+			if ctx.Err() == context.DeadlineExceeded {
+				// 请求方已超时，不再应答
+				return
+			}
+			getData.Response = rse
+			gb, gbErr := encodeObj(c.codec, getData)
+			if gbErr != nil {
+				Error("对象转字节错误...")
+			}
+			c.ReplyGet(getData.Id, code, gb)
+		}
 
-			case CommandReply:
-				reply := &Reply{}
-				bErr := ByteToObj(packet.Data, &reply)
-				if bErr != nil {
-					Error("返回的包解析失败， err = ", bErr)
+	case CommandReply:
+		reply := &Reply{}
+		bErr := decodeObj(packet.Codec, packet.Data, &reply)
+		if bErr != nil {
+			Error("返回的包解析失败， err = ", bErr)
+		}
+		if current, _ := c.currentServer(); from != current && CommandCode(reply.Type) != CommandConnect {
+			return
+		}
+		switch CommandCode(reply.Type) {
+		case CommandConnect: // 连接包与心跳包的反馈会触发
+			if reply.StateCode != ReplyStateSuccess {
+				if current, _ := c.currentServer(); from == current {
+					ErrorF("servers拒绝连接 state:%d", reply.StateCode)
+					c.connectDone(connectStateErr(reply.StateCode))
 				}
-				switch CommandCode(reply.Type) {
-				case CommandConnect: // 连接包与心跳包的反馈会触发
-					// 存储签名
-					c.sign = string(reply.Data)
-					c.state = 1
-					// 将积压的数据进行发送
-					c.SendBacklog()
-				case CommandPut:
-					if c.sign != packet.Sign {
-						Error("未知主机认证!")
-						return
-					}
-					if reply.StateCode != 0 {
-						// 签名错误
-						Error("签名错误")
-						break
-					}
-					// 服务端以确认收到删除对应的数据
-					backlogDel(reply.CtxId)
-
-				case CommandGet:
-					if c.sign != packet.Sign {
-						Error("未知主机认证!")
-						return
-					}
-					getData := &GetData{}
-					boErr := ByteToObj(reply.Data, &getData)
-					if boErr != nil {
-						Error("解析put err :", boErr)
-					}
-					getF, _ := GetDataMap.Load(getData.Id)
-					if getF != nil {
-						getF.(*GetData).Response = getData.Response
-						getF.(*GetData).ctxChan <- true
-					}
+				return
+			}
+			if !c.acceptServer(from) {
+				// 非当前servers且优先级更低，忽略
+				return
+			}
+			// 存储签名
+			c.setSign(string(reply.Data))
+			atomic.StoreInt32(&c.missed, 0)
+			c.setState(StateConnected)
+			c.connectDone(nil)
+			// 将积压的数据进行发送
+			c.SendBacklog()
+		case CommandPut:
+			if reply.StateCode == ReplyStateSignErr {
+				// 签名错误, 数据仍在积压中等待重传
+				Error("签名错误")
+				c.putAck(reply.CtxId, ErrPutSign)
+				// 应答带有s端当前的签名，c端已持有时只是该包携带了过期的签名，不必重新请求
+				// 否则每个签名错误都会触发连接并轮换签名，积压较多时重传的包会持续签名失败
+				if !c.checkSign(packet.Sign) {
+					c.ConnectServers()
 				}
+				break
 			}
-		}()
+			if !c.checkSign(packet.Sign) {
+				Error("未知主机认证!")
+				return
+			}
+			// 服务端以确认收到删除对应的数据，处理失败(如解码失败)时重传也不会成功，同样删除
+			if err := c.backlog.Delete(reply.CtxId); err != nil {
+				Error(err)
+			}
+			c.putAck(reply.CtxId, replyError(reply.StateCode, reply.Data))
+
+		case CommandGet:
+			if !c.checkSign(packet.Sign) {
+				Error("未知主机认证!")
+				return
+			}
+			getData := &GetData{}
+			boErr := decodeObj(packet.Codec, reply.Data, &getData)
+			if boErr != nil {
+				Error("解析put err :", boErr)
+			}
+			getDataDone(getData.Id, reply.StateCode, getData.Response)
+		}
 	}
 }
 
+// Close 关闭client并持久化积压数据，最多等待 DefaultShutdownTimeOut 秒
 func (c *Client) Close() {
 	if c.Conn == nil {
 		return
 	}
-	err := c.Conn.Close()
-	if err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultShutdownTimeOut*time.Second)
+	defer cancel()
+	if err := c.Shutdown(ctx); err != nil && err != ErrClientClosed {
 		Error(err.Error())
 	}
 }
 
+// Write 发送数据到当前servers
 func (c *Client) Write(data []byte) {
-	_, err := c.Conn.Write(data)
+	_, addr := c.currentServer()
+	c.writeTo(data, addr)
+}
+
+func (c *Client) writeTo(data []byte, addr net.Addr) {
+	_, err := c.Conn.WriteTo(data, addr)
 	if err != nil {
 		ErrorF("error write: %s", err.Error())
 	}
 }
 
+func (c *Client) packetConf() *packetConf {
+	return &packetConf{secret: c.secretKey, cipher: c.cipher, strict: c.strict, version: c.version, codec: c.codec,
+		compress: c.compress, compressMin: c.compressMin}
+}
+
+// send 封包并发送，数据过大时拆分为多个分片包发送
+func (c *Client) send(cmd CommandCode, data []byte) {
+	packets, err := packetEncoderFragment(cmd, c.name, c.getSign(), data, c.packetConf())
+	if err != nil {
+		Error(err)
+		return
+	}
+	for _, packet := range packets {
+		c.Write(packet)
+	}
+}
+
 // Put client put
 // 向服务端发送数据，如果服务端未在线数据会被积压，等服务器恢复后积压数据会一并发送
 func (c *Client) Put(funcLabel string, data []byte) {
+	err := c.put(PutData{
+		Label: funcLabel,
+		Id:    id(),
+		Body:  data,
+	})
+	if err != nil {
+		Error(err)
+	}
+}
+
+// PutWait 发送数据并等待服务端确认，直到收到确认或ctx结束
+// 返回 ErrPutSign, ErrPutTimeOut, ErrPutCanceled 时数据仍在积压中，连接恢复后会继续重传
+// ErrPutTimeOut, ErrPutCanceled 分别包装了 context.DeadlineExceeded, context.Canceled
+// 返回 *ReplyError 时servers已收到数据但处理失败，不会重传
+func (c *Client) PutWait(ctx context.Context, funcLabel string, data []byte) error {
+	return <-c.PutNotify(ctx, funcLabel, data)
+}
+
+// PutNotify 发送数据，服务端确认或ctx结束时将结果写入返回的chan
+func (c *Client) PutNotify(ctx context.Context, funcLabel string, data []byte) <-chan error {
 	putData := PutData{
 		Label: funcLabel,
 		Id:    id(),
 		Body:  data,
 	}
+	ack := make(chan error, 1)
+	c.putWait.Store(putData.Id, ack)
+	if err := c.put(putData); err != nil {
+		ack <- err
+	}
+	res := make(chan error, 1)
+	go func() {
+		var err error
+		select {
+		case err = <-ack:
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				err = ErrPutTimeOut
+			} else {
+				err = ErrPutCanceled
+			}
+		}
+		// 先移除等待再返回结果，调用方拿到结果时不会再有残留
+		c.putWait.Delete(putData.Id)
+		res <- err
+	}()
+	return res
+}
+
+// putAck 通知等待中的 PutWait
+func (c *Client) putAck(putId int64, err error) {
+	if v, ok := c.putWait.Load(putId); ok {
+		select {
+		case v.(chan error) <- err:
+		default:
+		}
+	}
+}
+
+func (c *Client) put(putData PutData) error {
+	if c.isClosed() {
+		return ErrClientClosed
+	}
 	// 数据被积压，占时保存
-	backlogAdd(putData.Id, putData)
-	// 未与servers端确认连接，不发送数据
-	if c.state != 1 {
-		return
+	if err := c.backlog.Append(putData); err != nil {
+		Error("积压数据存储失败 err = ", err)
 	}
-	b, err := ObjToByte(putData)
-	if err != nil {
-		Error("ObjToByte err = ", err)
+	// 未与servers端确认连接，不发送数据
+	if !c.online() {
+		return nil
 	}
-	packet, err := PacketEncoder(CommandPut, c.name, c.sign, c.secretKey, b)
+	b, err := encodeObj(c.codec, putData)
 	if err != nil {
-		Error(err)
+		return err
 	}
-	c.Write(packet)
+	c.send(CommandPut, b)
+	return nil
 }
 
 // 向服务端获取数据，指定一个超时时间，未应答就超时
 func (c *Client) get(timeOut int, funcLabel string, param []byte) ([]byte, error) {
-	getData := &GetData{
-		Label:    funcLabel,
-		Id:       id(),
-		Param:    param,
-		ctxChan:  make(chan bool),
-		Response: make([]byte, 0),
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*time.Duration(timeOut))
+	defer cancel()
+	return c.GetContext(ctx, funcLabel, param)
+}
+
+// GetContext 向服务端获取数据，ctx的截止时间会传给servers端的handler，ctx结束时返回超时或取消的错误
+// handler 返回非0的状态码时返回 *ReplyError，handler返回的数据为错误信息
+func (c *Client) GetContext(ctx context.Context, funcLabel string, param []byte) ([]byte, error) {
+	state, rse, err := c.getContext(ctx, funcLabel, param)
+	if err != nil {
+		return nil, err
+	}
+	if err = replyError(state, rse); err != nil {
+		return nil, err
 	}
+	return rse, nil
+}
+
+// getContext 向服务端获取数据，同时返回应答的状态码
+func (c *Client) getContext(ctx context.Context, funcLabel string, param []byte) (int, []byte, error) {
+	getData := newGetData(ctx, funcLabel, param)
 	GetDataMap.Store(getData.Id, getData)
-	b, err := ObjToByte(getData)
+	defer GetDataMap.Delete(getData.Id)
+	b, err := encodeObj(c.codec, getData)
 	if err != nil {
-		Error("ObjToByte err = ", err)
+		return 0, nil, err
 	}
-	packet, err := PacketEncoder(CommandGet, c.name, c.sign, c.secretKey, b)
-	if err != nil {
-		Error(err)
-	}
-	c.Write(packet)
+	c.send(CommandGet, b)
 	select {
-	case <-getData.ctxChan:
-		res := getData.Response
-		GetDataMap.Delete(getData.Id)
-		return res, nil
-	case <-time.After(time.Millisecond * time.Duration(timeOut)):
-		GetDataMap.Delete(getData.Id)
-		return nil, ErrSGetTimeOut(funcLabel, "servers", c.SConn.String())
+	case res := <-getData.ctxChan:
+		return res.state, res.response, nil
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			_, addr := c.currentServer()
+			return 0, nil, ErrSGetTimeOut(funcLabel, "servers", addr.String())
+		}
+		return 0, nil, ctx.Err()
 	}
-
 }
 
 // ReplyGet 返回put  state:0x0 成功   state:0x1 签名失败  state:2 业务层面的失败
@@ -316,15 +736,11 @@ func (c *Client) ReplyGet(id int64, state int, data []byte) {
 		Data:      data,
 		StateCode: state,
 	}
-	b, e := ObjToByte(reply)
+	b, e := encodeObj(c.codec, reply)
 	if e != nil {
 		Error("打包数据失败, e= ", e)
 	}
-	data, err := PacketEncoder(CommandReply, c.name, c.sign, c.secretKey, b)
-	if err != nil {
-		Error(err)
-	}
-	c.Write(data)
+	c.send(CommandReply, b)
 }
 
 func (c *Client) Get(funcLabel string, param []byte) ([]byte, error) {
@@ -336,6 +752,13 @@ func (c *Client) GetTimeOut(funcLabel string, param []byte, timeOut int) ([]byte
 }
 
 func (c *Client) GetHandleFunc(label string, f func(c *Client, param []byte) (int, []byte)) {
+	c.GetHandleFuncContext(label, func(ctx context.Context, c *Client, param []byte) (int, []byte) {
+		return f(c, param)
+	})
+}
+
+// GetHandleFuncContext 注册get方法，ctx携带请求方的剩余超时时间
+func (c *Client) GetHandleFuncContext(label string, f func(ctx context.Context, c *Client, param []byte) (int, []byte)) {
 	c.GetHandle[label] = f
 }
 
@@ -343,16 +766,103 @@ func (c *Client) NoticeHandleFunc(label string, f func(c *Client, data []byte))
 	c.NoticeHandle[label] = f
 }
 
+// Connect 阻塞直到servers下发签名，期间定时重发连接包
+// 返回 ErrConnectTimeOut(超时), ErrConnectCanceled(取消), ErrConnectCode(连接code不正确), ErrConnectSecret(秘钥不一致)
+// 连接结果依赖 Run 的读取循环，需在 go client.Run() 之后调用
+func (c *Client) Connect(ctx context.Context) error {
+	if c.isClosed() {
+		return ErrClientClosed
+	}
+	wait := make(chan error, 1)
+	c.connectLock.Lock()
+	if c.connectWait == nil {
+		c.connectWait = make(map[chan error]struct{})
+	}
+	c.connectWait[wait] = struct{}{}
+	c.connectLock.Unlock()
+	defer func() {
+		c.connectLock.Lock()
+		delete(c.connectWait, wait)
+		c.connectLock.Unlock()
+	}()
+	if c.State() == StateConnected {
+		return nil
+	}
+	ticker := time.NewTicker(DefaultConnectRetry * time.Millisecond)
+	defer ticker.Stop()
+	c.ConnectServers()
+	for {
+		select {
+		case err := <-wait:
+			return err
+		case <-ticker.C:
+			c.ConnectServers()
+		case <-c.done:
+			return ErrClientClosed
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return ErrConnectTimeOut
+			}
+			return ErrConnectCanceled
+		}
+	}
+}
+
+// connectDone 通知等待中的 Connect 连接结果
+func (c *Client) connectDone(err error) {
+	c.connectLock.Lock()
+	defer c.connectLock.Unlock()
+	for wait := range c.connectWait {
+		select {
+		case wait <- err:
+		default:
+		}
+	}
+}
+
+// connectStateErr servers拒绝连接的状态码对应的错误
+func connectStateErr(state int) error {
+	switch state {
+	case ReplyStateConnectCodeErr:
+		return ErrConnectCode
+	case ReplyStateSecretErr:
+		return ErrConnectSecret
+	}
+	return ErrConnectRejected(state)
+}
+
 // ConnectServers 请求连接服务器，获取签名
 // 内容是发送 Connect code
 func (c *Client) ConnectServers() {
-	data, err := PacketEncoder(CommandConnect, c.name, c.sign, c.secretKey, []byte(c.connectCode))
+	data, err := packetEncoder(CommandConnect, c.name, c.getSign(), []byte(c.connectCode), c.packetConf())
 	if err != nil {
 		Error(err)
 	}
 	c.Write(data)
 }
 
+func (c *Client) getSign() string {
+	c.signLock.RLock()
+	defer c.signLock.RUnlock()
+	return c.sign
+}
+
+func (c *Client) setSign(sign string) {
+	c.signLock.Lock()
+	defer c.signLock.Unlock()
+	if c.sign != sign {
+		c.prevSign = c.sign
+	}
+	c.sign = sign
+}
+
+// checkSign servers数据包的签名是否为当前或上一个签名
+func (c *Client) checkSign(sign string) bool {
+	c.signLock.RLock()
+	defer c.signLock.RUnlock()
+	return sign == c.sign || (c.prevSign != "" && sign == c.prevSign)
+}
+
 func (c *Client) GetName() string {
 	return c.name
 }
@@ -371,16 +881,27 @@ func (c *Client) DefaultSecretKey() {
 
 // 时间轮，持续制定时间发送心跳包
 func (c *Client) timeWheel() {
+	c.wg.Add(1)
 	go func() {
-		tTime := time.Duration(HeartbeatTime) // 时间轮5秒
+		defer c.wg.Done()
 		for {
 			// 5s维护一个心跳，s端收到心跳会返回新的签名
-			timer := time.NewTimer(tTime * time.Second)
+			timer := time.NewTimer(c.heartbeat)
 			select {
+			case <-c.done:
+				timer.Stop()
+				return
 			case <-timer.C:
-				// 这个时候表示连接不存在
-				c.state = 0
-				data, err := PacketEncoder(CommandHeartbeat, c.name, c.sign, c.secretKey, []byte(c.connectCode))
+				c.fragment.clean()
+				c.failbackProbe()
+				if atomic.LoadInt32(&c.reconnecting) == 1 {
+					// 重连中由重连协程发送连接包
+					continue
+				}
+				// 根据未应答的心跳更新连接状态
+				c.heartbeatMiss()
+				atomic.AddInt32(&c.missed, 1)
+				data, err := packetEncoder(CommandHeartbeat, c.name, c.getSign(), []byte(c.connectCode), c.packetConf())
 				if err != nil {
 					Error(err)
 				}
@@ -392,21 +913,20 @@ func (c *Client) timeWheel() {
 
 // SendBacklog 发送积压的数据，
 func (c *Client) SendBacklog() {
-	backlog.Range(func(key, value any) bool {
-		if value == nil {
-			return true
-		}
-		b, err := ObjToByte(value.(PutData))
-		if err != nil {
-			Error("ObjToByte err = ", err)
-		}
-		packet, err := PacketEncoder(CommandPut, c.name, c.sign, c.secretKey, b)
+	err := c.backlog.Range(func(putData PutData) bool {
+		b, err := encodeObj(c.codec, putData)
 		if err != nil {
-			Error(err)
+			Error("encode err = ", err)
 		}
-		c.Write(packet)
+		c.send(CommandPut, b)
 		return true
 	})
-	// 如果存在持久化积压数据则进行发送
-	BacklogLoad()
+	if err != nil {
+		Error(err)
+	}
+}
+
+// BacklogLen 当前积压的数据条数
+func (c *Client) BacklogLen() int64 {
+	return c.backlog.Len()
 }
@@ -0,0 +1,328 @@
+package udp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"runtime"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/mangenotwork/udp_comm/simnet"
+)
+
+// newSimClient 在模拟网络上创建 Client，conf 在创建前修改配置，servers 为空时连接 "servers"
+func newSimClient(t *testing.T, network *simnet.Network, addr string, conf func(cConf *ClientConf), servers ...net.Addr) (*Client, *simnet.Conn) {
+	t.Helper()
+	conn, err := network.Listen(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(servers) == 0 {
+		servers = []net.Addr{simnet.Addr("servers")}
+	}
+	cConf := ClientConf{
+		Name:         "sim",
+		ConnectCode:  DefaultConnectCode,
+		SecretKey:    DefaultSecretKey,
+		BacklogStore: NewMemoryBacklogStore(),
+	}
+	if conf != nil {
+		conf(&cConf)
+	}
+	c, err := NewClientWithConn(conn, servers, cConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, conn
+}
+
+// runSim 运行 Servers 与 Client，测试结束时关闭
+func runSim(t *testing.T, s *Servers, c *Client) {
+	t.Helper()
+	if s != nil {
+		go func() {
+			_ = s.Run()
+		}()
+	}
+	if c != nil {
+		go func() {
+			_ = c.Run()
+		}()
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if c != nil {
+			_ = c.Shutdown(ctx)
+		}
+		if s != nil {
+			_ = s.Shutdown(ctx)
+		}
+	})
+}
+
+func TestClientShutdown(t *testing.T) {
+	network := simnet.New(simnet.Conf{}, 1)
+	base := runtime.NumGoroutine()
+	c, _ := newSimClient(t, network, "client", nil)
+	run := runErr(c.Run)
+	time.Sleep(20 * time.Millisecond)
+	if err := c.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitRun(t, run); err != ErrClientClosed {
+		t.Fatalf("Run err = %v", err)
+	}
+	if err := c.Shutdown(context.Background()); err != ErrClientClosed {
+		t.Fatalf("再次 Shutdown err = %v", err)
+	}
+	if c.State() != StateClosed {
+		t.Fatalf("state = %s", c.State())
+	}
+	waitGoroutines(t, base)
+}
+
+func TestClientShutdownBeforeRun(t *testing.T) {
+	network := simnet.New(simnet.Conf{}, 1)
+	base := runtime.NumGoroutine()
+	c, _ := newSimClient(t, network, "client", nil)
+	if err := c.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Run(); err != ErrClientClosed {
+		t.Fatalf("Run err = %v", err)
+	}
+	waitGoroutines(t, base)
+
+	// Run 与 Shutdown 同时调用
+	for i := 0; i < 20; i++ {
+		c, _ := newSimClient(t, network, "race", nil)
+		run := runErr(c.Run)
+		if err := c.Shutdown(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if err := waitRun(t, run); err != ErrClientClosed {
+			t.Fatalf("Run err = %v", err)
+		}
+	}
+	waitGoroutines(t, base)
+}
+
+// TestClientConnClosed 连接被外部关闭时 Run 返回错误而不是一直重试
+func TestClientConnClosed(t *testing.T) {
+	network := simnet.New(simnet.Conf{}, 1)
+	base := runtime.NumGoroutine()
+	c, conn := newSimClient(t, network, "client", nil)
+	run := runErr(c.Run)
+	time.Sleep(20 * time.Millisecond)
+	_ = conn.Close()
+	if err := waitRun(t, run); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("Run err = %v", err)
+	}
+	_ = c.Shutdown(context.Background())
+	waitGoroutines(t, base)
+}
+
+// TestClientFailover 首选servers丢失后切换到备用servers，恢复后切回首选
+func TestClientFailover(t *testing.T) {
+	network := simnet.New(simnet.Conf{}, 1)
+	primary, primaryConn := newSimServers(t, network, "primary")
+	backup, backupConn := newSimServers(t, network, "backup")
+	got := map[*Servers]*received{primary: newReceived(), backup: newReceived()}
+	for s, r := range got {
+		r := r
+		s.PutHandleFunc("put", func(s *Servers, c *ClientInfo, data []byte) {
+			r.add(data)
+		})
+	}
+	c, cConn := newSimClient(t, network, "client", func(cConf *ClientConf) {
+		cConf.Heartbeat = 50 * time.Millisecond
+		cConf.Failback = 200 * time.Millisecond
+		cConf.Reconnect = ReconnectPolicy{InitialDelay: 20 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+	}, primaryConn.LocalAddr(), backupConn.LocalAddr())
+	runSim(t, primary, nil)
+	runSim(t, backup, c)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := c.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// put 直到 servers s 收到，返回是否收到
+	putTo := func(s *Servers, data string) bool {
+		deadline := time.Now().Add(3 * time.Second)
+		for time.Now().Before(deadline) {
+			c.Put("put", []byte(data))
+			time.Sleep(20 * time.Millisecond)
+			if got[s].has(data) {
+				return true
+			}
+		}
+		return false
+	}
+	if !putTo(primary, "primary-1") {
+		t.Fatal("首选servers未收到数据")
+	}
+
+	network.Partition(cConn.LocalAddr(), primaryConn.LocalAddr())
+	waitFor(t, 3*time.Second, "未切换到备用servers", func() bool {
+		i, _ := c.currentServer()
+		return i == 1 && c.online()
+	})
+	if !putTo(backup, "backup-1") {
+		t.Fatal("备用servers未收到数据")
+	}
+
+	network.HealAll()
+	waitFor(t, 3*time.Second, "未切回首选servers", func() bool {
+		i, _ := c.currentServer()
+		return i == 0 && c.online()
+	})
+	if !putTo(primary, "primary-2") {
+		t.Fatal("切回后首选servers未收到数据")
+	}
+}
+
+// fakeServers 对每个连接包应答指定的状态码
+func fakeServers(t *testing.T, network *simnet.Network, state int) {
+	t.Helper()
+	conn, err := network.Listen("servers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			head, err := packetHeader(buf[:n], n)
+			if err != nil || head.command != CommandConnect {
+				continue
+			}
+			conf := &packetConf{secret: DefaultSecretKey, cipher: head.cipher, version: head.version, codec: head.codec}
+			b, _ := encodeObj(conf.codec, &Reply{Type: int(CommandConnect), StateCode: state})
+			data, err := packetEncoder(CommandReply, "servers", "", b, conf)
+			if err != nil {
+				continue
+			}
+			_, _ = conn.WriteTo(data, addr)
+		}
+	}()
+}
+
+// TestConnectRejected servers拒绝连接时 Connect 返回对应的错误
+func TestConnectRejected(t *testing.T) {
+	cases := []struct {
+		name  string
+		setup func(t *testing.T, network *simnet.Network)
+		conf  func(cConf *ClientConf)
+		want  error
+	}{
+		{"state 3", func(t *testing.T, network *simnet.Network) {
+			fakeServers(t, network, ReplyStateConnectCodeErr)
+		}, nil, ErrConnectCode},
+		{"state 4", func(t *testing.T, network *simnet.Network) {
+			fakeServers(t, network, ReplyStateSecretErr)
+		}, nil, ErrConnectSecret},
+		{"connect code", func(t *testing.T, network *simnet.Network) {
+			s, _ := newSimServers(t, network, "servers")
+			runSim(t, s, nil)
+		}, func(cConf *ClientConf) {
+			cConf.ConnectCode = "wrong"
+		}, ErrConnectCode},
+		{"secret", func(t *testing.T, network *simnet.Network) {
+			s, _ := newSimServers(t, network, "servers")
+			if err := s.SetCipher(CipherAESGCM); err != nil {
+				t.Fatal(err)
+			}
+			runSim(t, s, nil)
+		}, func(cConf *ClientConf) {
+			cConf.Cipher = CipherAESGCM
+			cConf.SecretKey = "87654321"
+		}, ErrConnectSecret},
+	}
+	for _, v := range cases {
+		t.Run(v.name, func(t *testing.T) {
+			network := simnet.New(simnet.Conf{}, 1)
+			v.setup(t, network)
+			c, _ := newSimClient(t, network, "client", v.conf)
+			runSim(t, nil, c)
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			if err := c.Connect(ctx); err != v.want {
+				t.Fatalf("Connect err = %v, 应为 %v", err, v.want)
+			}
+		})
+	}
+}
+
+// TestClientDefaultBacklog 默认配置的两个Client不共用积压数据，内存与持久化的数据都各自独立
+func TestClientDefaultBacklog(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.Chdir(wd)
+	}()
+	network := simnet.New(simnet.Conf{}, 1)
+	servers := []net.Addr{simnet.Addr("servers")}
+	newDefault := func(addr string) *Client {
+		conn, err := network.Listen(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := NewClientWithConn(conn, servers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	ids := func(c *Client) []int64 {
+		list := make([]int64, 0)
+		_ = c.backlog.Range(func(putData PutData) bool {
+			list = append(list, putData.Id)
+			return true
+		})
+		sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+		return list
+	}
+	a, b := newDefault("a"), newDefault("b")
+	if a.backlogDir == b.backlogDir {
+		t.Fatalf("两个Client使用同一个积压目录 %s", a.backlogDir)
+	}
+	_ = a.backlog.Append(PutData{Label: "a", Id: 1})
+	_ = b.backlog.Append(PutData{Label: "b", Id: 2})
+	if got := ids(a); !equalIds(got, []int64{1}) {
+		t.Fatalf("a 内存中的积压 %v", got)
+	}
+	// 持久化后各自只加载自己的数据
+	if err := a.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// 重启后按创建顺序使用之前的目录，各自只加载自己持久化的数据
+	a, b = newDefault("a2"), newDefault("b2")
+	defer a.Close()
+	defer b.Close()
+	_, _ = ids(a), ids(b) // 第一次遍历时加载持久化数据
+	if got := ids(a); !equalIds(got, []int64{1}) {
+		t.Fatalf("重启后 a 的积压 %v", got)
+	}
+	if got := ids(b); !equalIds(got, []int64{2}) {
+		t.Fatalf("重启后 b 的积压 %v", got)
+	}
+}
@@ -0,0 +1,455 @@
+package udp
+
+import (
+	"encoding"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"sync"
+)
+
+// Codec 信封结构(PutData, GetData, NoticeData, Reply)的编解码，使用的编码记录在v1包头中
+type Codec interface {
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// CodecType 包头中的编码id
+type CodecType uint8
+
+const (
+	CodecBinary CodecType = 0x0 // 紧凑的二进制编码，默认值
+	CodecJSON   CodecType = 0x1 // JSON 旧版本的编码，[]byte 会被base64编码，v0包头总是使用JSON
+)
+
+var (
+	codecs    = map[CodecType]Codec{CodecBinary: binaryCodec{}, CodecJSON: jsonCodec{}}
+	codecLock sync.RWMutex
+)
+
+// RegisterCodec 注册自定义编码，两端需要注册相同的id，id已存在时panic
+func RegisterCodec(id CodecType, codec Codec) {
+	codecLock.Lock()
+	defer codecLock.Unlock()
+	if _, ok := codecs[id]; ok {
+		PanicCodecExist(id)
+	}
+	codecs[id] = codec
+}
+
+func getCodec(id CodecType) (Codec, error) {
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+	codec, ok := codecs[id]
+	if !ok {
+		return nil, ErrCodec(id)
+	}
+	return codec, nil
+}
+
+func (c CodecType) String() string {
+	codec, err := getCodec(c)
+	if err != nil {
+		return "unknown"
+	}
+	return codec.Name()
+}
+
+// encodeObj 使用id对应的编码编码信封结构
+func encodeObj(id CodecType, obj interface{}) ([]byte, error) {
+	codec, err := getCodec(id)
+	if err != nil {
+		return nil, err
+	}
+	return codec.Marshal(obj)
+}
+
+// decodeObj 使用id对应的编码解码信封结构
+func decodeObj(id CodecType, data []byte, obj interface{}) error {
+	codec, err := getCodec(id)
+	if err != nil {
+		return err
+	}
+	return codec.Unmarshal(data, obj)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+/*
+
+binaryCodec 紧凑的二进制编码
+按字段的声明顺序依次编码，不包含字段名，两端的结构需要一致
+- bool: 1字节
+- int: zigzag varint,  uint: varint,  float: 4/8字节
+- string, []byte: varint长度 + 数据
+- slice, map: varint长度 + 元素，array: 元素
+- struct: 导出的字段，指针与error: 1字节是否为nil + 值(error为错误信息)
+- 实现了 encoding.BinaryMarshaler 的类型(如 time.Time): varint长度 + MarshalBinary
+
+*/
+
+type binaryCodec struct{}
+
+var (
+	errorType             = reflect.TypeOf((*error)(nil)).Elem()
+	binaryMarshalerType   = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+)
+
+func (binaryCodec) Name() string {
+	return "binary"
+}
+
+// Marshal 最外层的指针不参与编码，编码 T 与 *T 的结果相同
+func (binaryCodec) Marshal(v interface{}) ([]byte, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() || rv.Kind() == reflect.Ptr {
+		return nil, ErrCodecValue
+	}
+	return appendValue(make([]byte, 0, 64), rv)
+}
+
+// Unmarshal v 可以是 *T 或 **T，与 json.Unmarshal 相同，nil的指针会被分配
+func (binaryCodec) Unmarshal(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return ErrCodecValue
+	}
+	rv = rv.Elem()
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		rv = rv.Elem()
+	}
+	d := &binaryDecoder{data: data}
+	if err := d.value(rv); err != nil {
+		return err
+	}
+	if len(d.data) != 0 {
+		return ErrCodecData
+	}
+	return nil
+}
+
+func appendValue(b []byte, v reflect.Value) ([]byte, error) {
+	t := v.Type()
+	if t.Kind() != reflect.Ptr && t.Kind() != reflect.Interface && t.Implements(binaryMarshalerType) {
+		data, err := v.Interface().(encoding.BinaryMarshaler).MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		b = binary.AppendUvarint(b, uint64(len(data)))
+		return append(b, data...), nil
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return append(b, 1), nil
+		}
+		return append(b, 0), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return binary.AppendVarint(b, v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return binary.AppendUvarint(b, v.Uint()), nil
+	case reflect.Float32:
+		return binary.LittleEndian.AppendUint32(b, math.Float32bits(float32(v.Float()))), nil
+	case reflect.Float64:
+		return binary.LittleEndian.AppendUint64(b, math.Float64bits(v.Float())), nil
+	case reflect.String:
+		b = binary.AppendUvarint(b, uint64(v.Len()))
+		return append(b, v.String()...), nil
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			b = binary.AppendUvarint(b, uint64(v.Len()))
+			return append(b, v.Bytes()...), nil
+		}
+		b = binary.AppendUvarint(b, uint64(v.Len()))
+		return appendElems(b, v)
+	case reflect.Array:
+		return appendElems(b, v)
+	case reflect.Map:
+		b = binary.AppendUvarint(b, uint64(v.Len()))
+		var err error
+		iter := v.MapRange()
+		for iter.Next() {
+			if b, err = appendValue(b, iter.Key()); err != nil {
+				return nil, err
+			}
+			if b, err = appendValue(b, iter.Value()); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case reflect.Struct:
+		var err error
+		for _, i := range exportedFields(t) {
+			if b, err = appendValue(b, v.Field(i)); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case reflect.Ptr:
+		if v.IsNil() {
+			return append(b, 0), nil
+		}
+		return appendValue(append(b, 1), v.Elem())
+	case reflect.Interface:
+		if t != errorType {
+			break
+		}
+		if v.IsNil() {
+			return append(b, 0), nil
+		}
+		msg := v.Interface().(error).Error()
+		b = binary.AppendUvarint(append(b, 1), uint64(len(msg)))
+		return append(b, msg...), nil
+	}
+	return nil, ErrCodecType(t.String())
+}
+
+func appendElems(b []byte, v reflect.Value) ([]byte, error) {
+	var err error
+	for i := 0; i < v.Len(); i++ {
+		if b, err = appendValue(b, v.Index(i)); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// structFields 结构 -> 导出字段的序号
+var structFields sync.Map
+
+func exportedFields(t reflect.Type) []int {
+	if v, ok := structFields.Load(t); ok {
+		return v.([]int)
+	}
+	list := make([]int, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).IsExported() {
+			list = append(list, i)
+		}
+	}
+	structFields.Store(t, list)
+	return list
+}
+
+type binaryDecoder struct {
+	data []byte
+}
+
+func (d *binaryDecoder) uvarint() (uint64, error) {
+	x, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		return 0, ErrCodecData
+	}
+	d.data = d.data[n:]
+	return x, nil
+}
+
+func (d *binaryDecoder) varint() (int64, error) {
+	x, n := binary.Varint(d.data)
+	if n <= 0 {
+		return 0, ErrCodecData
+	}
+	d.data = d.data[n:]
+	return x, nil
+}
+
+func (d *binaryDecoder) bytes(n int) ([]byte, error) {
+	if n < 0 || n > len(d.data) {
+		return nil, ErrCodecData
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b, nil
+}
+
+// length 读取长度，每个元素至少占用1字节，长度不可能超过剩余的数据
+func (d *binaryDecoder) length() (int, error) {
+	x, err := d.uvarint()
+	if err != nil {
+		return 0, err
+	}
+	if x > uint64(len(d.data)) {
+		return 0, ErrCodecData
+	}
+	return int(x), nil
+}
+
+func (d *binaryDecoder) value(v reflect.Value) error {
+	t := v.Type()
+	if t.Kind() != reflect.Ptr && t.Kind() != reflect.Interface && reflect.PtrTo(t).Implements(binaryUnmarshalerType) {
+		n, err := d.length()
+		if err != nil {
+			return err
+		}
+		data, err := d.bytes(n)
+		if err != nil {
+			return err
+		}
+		return v.Addr().Interface().(encoding.BinaryUnmarshaler).UnmarshalBinary(data)
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		b, err := d.bytes(1)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b[0] != 0)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		x, err := d.varint()
+		if err != nil {
+			return err
+		}
+		if v.OverflowInt(x) {
+			return ErrCodecData
+		}
+		v.SetInt(x)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		x, err := d.uvarint()
+		if err != nil {
+			return err
+		}
+		if v.OverflowUint(x) {
+			return ErrCodecData
+		}
+		v.SetUint(x)
+		return nil
+	case reflect.Float32:
+		b, err := d.bytes(4)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(b))))
+		return nil
+	case reflect.Float64:
+		b, err := d.bytes(8)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(math.Float64frombits(binary.LittleEndian.Uint64(b)))
+		return nil
+	case reflect.String:
+		n, err := d.length()
+		if err != nil {
+			return err
+		}
+		b, err := d.bytes(n)
+		if err != nil {
+			return err
+		}
+		v.SetString(string(b))
+		return nil
+	case reflect.Slice:
+		n, err := d.length()
+		if err != nil {
+			return err
+		}
+		if t.Elem().Kind() == reflect.Uint8 {
+			b, err := d.bytes(n)
+			if err != nil {
+				return err
+			}
+			v.SetBytes(append([]byte{}, b...))
+			return nil
+		}
+		s := reflect.MakeSlice(t, n, n)
+		for i := 0; i < n; i++ {
+			if err := d.value(s.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+		return nil
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := d.value(v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		n, err := d.length()
+		if err != nil {
+			return err
+		}
+		m := reflect.MakeMapWithSize(t, n)
+		for i := 0; i < n; i++ {
+			key, val := reflect.New(t.Key()).Elem(), reflect.New(t.Elem()).Elem()
+			if err := d.value(key); err != nil {
+				return err
+			}
+			if err := d.value(val); err != nil {
+				return err
+			}
+			m.SetMapIndex(key, val)
+		}
+		v.Set(m)
+		return nil
+	case reflect.Struct:
+		for _, i := range exportedFields(t) {
+			if err := d.value(v.Field(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Ptr:
+		b, err := d.bytes(1)
+		if err != nil {
+			return err
+		}
+		if b[0] == 0 {
+			v.Set(reflect.Zero(t))
+			return nil
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(t.Elem()))
+		}
+		return d.value(v.Elem())
+	case reflect.Interface:
+		if t != errorType {
+			break
+		}
+		b, err := d.bytes(1)
+		if err != nil {
+			return err
+		}
+		if b[0] == 0 {
+			v.Set(reflect.Zero(t))
+			return nil
+		}
+		n, err := d.length()
+		if err != nil {
+			return err
+		}
+		msg, err := d.bytes(n)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(errors.New(string(msg))))
+		return nil
+	}
+	return ErrCodecType(t.String())
+}
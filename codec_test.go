@@ -0,0 +1,201 @@
+package udp
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mangenotwork/udp_comm/simnet"
+)
+
+func TestCodecEnvelope(t *testing.T) {
+	body := bytes.Repeat([]byte{0, 1, 2, 0xff}, 100)
+	for _, id := range []CodecType{CodecBinary, CodecJSON} {
+		put := &PutData{Label: "label", Id: 42, Body: body}
+		b, err := encodeObj(id, put)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := &PutData{}
+		if err := decodeObj(id, b, &got); err != nil {
+			t.Fatalf("%s: %v", id, err)
+		}
+		if got.Label != put.Label || got.Id != put.Id || !bytes.Equal(got.Body, put.Body) {
+			t.Fatalf("%s: 解码结果不一致 %+v", id, got)
+		}
+
+		get := &GetData{Label: "get", Id: -7, Param: []byte("param"), Timeout: 1000, Response: body}
+		reply := &Reply{Type: int(CommandReply), CtxId: 3, StateCode: ReplyStateCustom}
+		if reply.Data, err = encodeObj(id, get); err != nil {
+			t.Fatal(err)
+		}
+		if b, err = encodeObj(id, reply); err != nil {
+			t.Fatal(err)
+		}
+		gotReply, gotGet := &Reply{}, &GetData{}
+		if err := decodeObj(id, b, gotReply); err != nil {
+			t.Fatalf("%s: %v", id, err)
+		}
+		if err := decodeObj(id, gotReply.Data, &gotGet); err != nil {
+			t.Fatalf("%s: %v", id, err)
+		}
+		if gotReply.CtxId != 3 || gotReply.StateCode != ReplyStateCustom || gotGet.Id != -7 ||
+			gotGet.Timeout != 1000 || !bytes.Equal(gotGet.Response, body) {
+			t.Fatalf("%s: 解码结果不一致 %+v %+v", id, gotReply, gotGet)
+		}
+	}
+}
+
+// TestCodecBinarySize 二进制编码不对[]byte做base64，应明显小于JSON
+func TestCodecBinarySize(t *testing.T) {
+	put := &PutData{Label: "label", Id: 1, Body: bytes.Repeat([]byte("x"), 300)}
+	bin, err := encodeObj(CodecBinary, put)
+	if err != nil {
+		t.Fatal(err)
+	}
+	js, err := encodeObj(CodecJSON, put)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bin) >= len(put.Body)+16 || len(bin)*4/3 > len(js) {
+		t.Fatalf("binary:%d json:%d", len(bin), len(js))
+	}
+}
+
+func TestCodecBinaryValues(t *testing.T) {
+	type inner struct {
+		At time.Time
+		F  float64
+	}
+	type value struct {
+		B      bool
+		I8     int8
+		U      uint64
+		F32    float32
+		S      string
+		List   []string
+		Arr    [2]int
+		M      map[string]int
+		P      *inner
+		Nil    *inner
+		Err    error
+		hidden int
+	}
+	v := value{
+		B: true, I8: -128, U: 1 << 63, F32: 1.5, S: "名字", List: []string{"a", ""},
+		Arr: [2]int{-1, 1}, M: map[string]int{"k": 9},
+		P:   &inner{At: time.Unix(1700000000, 123).UTC(), F: -0.25},
+		Err: errors.New("err"), hidden: 1,
+	}
+	b, err := encodeObj(CodecBinary, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got value
+	if err := decodeObj(CodecBinary, b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.B != v.B || got.I8 != v.I8 || got.U != v.U || got.F32 != v.F32 || got.S != v.S ||
+		len(got.List) != 2 || got.List[0] != "a" || got.Arr != v.Arr || got.M["k"] != 9 ||
+		got.P == nil || !got.P.At.Equal(v.P.At) || got.P.F != v.P.F || got.Nil != nil ||
+		got.Err == nil || got.Err.Error() != "err" || got.hidden != 0 {
+		t.Fatalf("解码结果不一致 %+v", got)
+	}
+
+	if _, err := encodeObj(CodecBinary, struct{ C chan int }{}); err == nil {
+		t.Fatal("不支持的类型应返回错误")
+	}
+	if _, err := encodeObj(CodecBinary, nil); err != ErrCodecValue {
+		t.Fatalf("err = %v", err)
+	}
+	if err := decodeObj(CodecBinary, b, got); err != ErrCodecValue {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestCodecBinaryCorrupt(t *testing.T) {
+	b, err := encodeObj(CodecBinary, &PutData{Label: "label", Id: 1, Body: []byte("body")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string][]byte{
+		"short":    b[:len(b)-1],
+		"trailing": append(append([]byte{}, b...), 0),
+		"length":   append([]byte{0xff, 0xff, 0x03}, b[1:]...),
+		"empty":    nil,
+	}
+	for name, data := range cases {
+		if err := decodeObj(CodecBinary, data, &PutData{}); err != ErrCodecData {
+			t.Fatalf("%s: err = %v", name, err)
+		}
+	}
+}
+
+type upperCodec struct{ jsonCodec }
+
+func (upperCodec) Name() string {
+	return "upper"
+}
+
+func TestRegisterCodec(t *testing.T) {
+	const id CodecType = 0x7f
+	if _, err := getCodec(id); err != nil {
+		RegisterCodec(id, upperCodec{}) // -count 多次运行时已注册
+	}
+	if id.String() != "upper" || CodecType(0x7e).String() != "unknown" {
+		t.Fatalf("名称不一致 %s", id)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("重复注册应panic")
+			}
+		}()
+		RegisterCodec(id, upperCodec{})
+	}()
+
+	conf := &packetConf{secret: DefaultSecretKey, cipher: CipherDES, version: PacketV1, codec: id}
+	stream, err := packetEncoder(CommandPut, "name", "abcdefg", []byte("data"), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet, err := packetDecrypt(stream, len(stream), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if packet.Codec != id {
+		t.Fatalf("codec = %d", packet.Codec)
+	}
+	if _, err := packetDecrypt(resealCRC(append(stream[:6:6], append([]byte{0x7e}, stream[7:]...)...)), len(stream), conf); err == nil {
+		t.Fatal("未注册的编码应返回错误")
+	}
+	if _, err := newClient("", ClientConf{SecretKey: DefaultSecretKey, Codec: 0x7e}); err == nil {
+		t.Fatal("未注册的编码应返回错误")
+	}
+}
+
+// TestCodecMixed servers使用二进制编码，c端使用JSON，应答使用c端的编码
+func TestCodecMixed(t *testing.T) {
+	network := simnet.New(simnet.Conf{}, 1)
+	got := newReceived()
+	_, c := simPairConf(t, network, func(sConf *ServersConf, cConf *ClientConf) {
+		cConf.Codec = CodecJSON
+	}, func(s *Servers, c *Client) {
+		s.PutHandleFunc("put", func(s *Servers, c *ClientInfo, data []byte) {
+			got.add(data)
+		})
+		s.GetHandleFunc("get", func(s *Servers, param []byte) (int, []byte) {
+			return 0, append([]byte("re:"), param...)
+		})
+	})
+	c.Put("put", []byte("data"))
+	rse, err := c.Get("get", []byte("param"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rse) != "re:param" {
+		t.Fatalf("rse = %s", rse)
+	}
+	waitFor(t, 2*time.Second, "put 未送达", func() bool { return got.len() == 1 })
+}
@@ -11,6 +11,7 @@ const (
 	CommandHeartbeat CommandCode = 0x3 // 发送心跳
 	CommandNotice    CommandCode = 0x4 // 下发签名
 	CommandGet       CommandCode = 0x5 // 获取消息
+	CommandFragment  CommandCode = 0x6 // 分片包，重组后还原为原指令
 )
 
 // CommandPut,CommandGet  必须验证签名，否则不接收， 签名由client主导
@@ -0,0 +1,185 @@
+package udp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"sync"
+)
+
+// Compressor data的压缩算法，使用的算法记录在v1包头中
+type Compressor interface {
+	Name() string
+	Compress(src []byte) ([]byte, error)
+	Decompress(src []byte) ([]byte, error)
+}
+
+// CompressType 包头中的压缩算法id
+type CompressType uint8
+
+const (
+	CompressZlib CompressType = 0x0 // zlib 旧版本的压缩算法，默认值，v0包头总是使用zlib
+	CompressNone CompressType = 0x1 // 不压缩
+	CompressGzip CompressType = 0x2 // gzip
+)
+
+var (
+	compressors = map[CompressType]Compressor{
+		CompressZlib: zlibCompressor{},
+		CompressNone: noneCompressor{},
+		CompressGzip: gzipCompressor{},
+	}
+	compressLock sync.RWMutex
+)
+
+// RegisterCompressor 注册自定义压缩算法，两端需要注册相同的id，id已存在时panic
+func RegisterCompressor(id CompressType, compressor Compressor) {
+	compressLock.Lock()
+	defer compressLock.Unlock()
+	if _, ok := compressors[id]; ok {
+		PanicCompressExist(id)
+	}
+	compressors[id] = compressor
+}
+
+func getCompressor(id CompressType) (Compressor, error) {
+	compressLock.RLock()
+	defer compressLock.RUnlock()
+	compressor, ok := compressors[id]
+	if !ok {
+		return nil, ErrCompress(id)
+	}
+	return compressor, nil
+}
+
+func (c CompressType) String() string {
+	compressor, err := getCompressor(c)
+	if err != nil {
+		return "unknown"
+	}
+	return compressor.Name()
+}
+
+// compressMin 配置的最小压缩字节数，0使用默认值，小于0时总是压缩
+func compressMin(min int) int {
+	if min == 0 {
+		return DefaultCompressMin
+	}
+	if min < 0 {
+		return 0
+	}
+	return min
+}
+
+// packetCompress 压缩data，返回实际使用的算法
+// 数据小于 compressMin 或压缩后没有变小(如已压缩的数据)时不压缩，v0 包头没有压缩标志，总是使用zlib
+func packetCompress(conf *packetConf, data []byte) ([]byte, CompressType, error) {
+	if conf.version == PacketV0 {
+		return ZlibCompress(data), CompressZlib, nil
+	}
+	if conf.compress == CompressNone || len(data) < conf.compressMin {
+		return data, CompressNone, nil
+	}
+	compressor, err := getCompressor(conf.compress)
+	if err != nil {
+		return nil, CompressNone, err
+	}
+	b, err := compressor.Compress(data)
+	if err != nil {
+		return nil, CompressNone, err
+	}
+	if len(b) >= len(data) {
+		return data, CompressNone, nil
+	}
+	return b, conf.compress, nil
+}
+
+// limitDecompressor 解压时限制输出长度的压缩算法，内置的zlib与gzip实现了该接口
+type limitDecompressor interface {
+	DecompressLimit(src []byte, max int) ([]byte, error)
+}
+
+// decompress 解压data，解压后大于 max 个字节时返回 ErrDecompressAbove，防止压缩炸弹
+// 未实现 limitDecompressor 的自定义算法只能在解压后检查长度
+func decompress(compressor Compressor, src []byte, max int) ([]byte, error) {
+	if c, ok := compressor.(limitDecompressor); ok {
+		return c.DecompressLimit(src, max)
+	}
+	b, err := compressor.Decompress(src)
+	if err == nil && len(b) > max {
+		return nil, ErrDecompressAbove
+	}
+	return b, err
+}
+
+// readLimit 读取全部数据，超过 max 个字节时返回 ErrDecompressAbove
+func readLimit(r io.ReadCloser, max int) ([]byte, error) {
+	defer r.Close()
+	b, err := io.ReadAll(io.LimitReader(r, int64(max)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > max {
+		return nil, ErrDecompressAbove
+	}
+	return b, nil
+}
+
+type zlibCompressor struct{}
+
+func (zlibCompressor) Name() string {
+	return "zlib"
+}
+
+func (zlibCompressor) Compress(src []byte) ([]byte, error) {
+	return ZlibCompress(src), nil
+}
+
+func (zlibCompressor) Decompress(src []byte) ([]byte, error) {
+	return ZlibDecompress(src)
+}
+
+func (zlibCompressor) DecompressLimit(src []byte, max int) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	return readLimit(r, max)
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Name() string {
+	return "gzip"
+}
+
+func (gzipCompressor) Compress(src []byte) ([]byte, error) {
+	return GzipCompress(src), nil
+}
+
+func (gzipCompressor) Decompress(src []byte) ([]byte, error) {
+	return GzipDecompress(src)
+}
+
+func (gzipCompressor) DecompressLimit(src []byte, max int) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	return readLimit(r, max)
+}
+
+type noneCompressor struct{}
+
+func (noneCompressor) Name() string {
+	return "none"
+}
+
+func (noneCompressor) Compress(src []byte) ([]byte, error) {
+	return src, nil
+}
+
+func (noneCompressor) Decompress(src []byte) ([]byte, error) {
+	return src, nil
+}
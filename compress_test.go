@@ -0,0 +1,176 @@
+package udp
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/mangenotwork/udp_comm/simnet"
+)
+
+func TestPacketCompress(t *testing.T) {
+	text := bytes.Repeat([]byte("compress "), 50)
+	random := make([]byte, 400)
+	_, _ = rand.Read(random)
+	cases := []struct {
+		name     string
+		version  uint8
+		compress CompressType
+		min      int
+		data     []byte
+		want     CompressType
+	}{
+		{"zlib", PacketV1, CompressZlib, DefaultCompressMin, text, CompressZlib},
+		{"gzip", PacketV1, CompressGzip, DefaultCompressMin, text, CompressGzip},
+		{"none", PacketV1, CompressNone, DefaultCompressMin, text, CompressNone},
+		{"min", PacketV1, CompressZlib, DefaultCompressMin, []byte("c"), CompressNone},
+		{"random", PacketV1, CompressZlib, DefaultCompressMin, random, CompressNone},
+		{"always", PacketV1, CompressGzip, compressMin(-1), bytes.Repeat([]byte("a"), 40), CompressGzip},
+		{"v0", PacketV0, CompressNone, DefaultCompressMin, []byte("c"), CompressZlib},
+	}
+	for _, v := range cases {
+		conf := &packetConf{secret: DefaultSecretKey, cipher: CipherAESGCM, version: v.version,
+			compress: v.compress, compressMin: v.min}
+		stream, err := packetEncoder(CommandPut, "name", "abcdefg", v.data, conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		head, err := packetHeader(stream, len(stream))
+		if err != nil {
+			t.Fatalf("%s: %v", v.name, err)
+		}
+		if head.compress != v.want || (head.flags&flagCompressed != 0) != (v.want != CompressNone) {
+			t.Fatalf("%s: compress = %s flags = %d, 应为 %s", v.name, head.compress, head.flags, v.want)
+		}
+		packet, err := packetDecode(head, stream, conf)
+		if err != nil {
+			t.Fatalf("%s: %v", v.name, err)
+		}
+		if !bytes.Equal(packet.Data, v.data) {
+			t.Fatalf("%s: 解包结果不一致", v.name)
+		}
+	}
+}
+
+func TestPacketCompressReject(t *testing.T) {
+	conf := &packetConf{secret: DefaultSecretKey, cipher: CipherDES, version: PacketV1}
+	stream, err := packetEncoder(CommandPut, "name", "abcdefg", bytes.Repeat([]byte("data"), 100), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 标志与压缩算法不一致
+	b := append([]byte{}, stream...)
+	b[7] = uint8(CompressNone)
+	if _, err := packetHeader(resealCRC(b), len(b)); err != ErrPacketHead {
+		t.Fatalf("err = %v", err)
+	}
+	// 未注册的压缩算法
+	b = append([]byte{}, stream...)
+	b[7] = 0x7e
+	if _, err := packetDecrypt(resealCRC(b), len(b), conf); err == nil || err.Error() != ErrCompress(0x7e).Error() {
+		t.Fatalf("err = %v", err)
+	}
+	if _, err := newServers(ServersConf{SecretKey: DefaultSecretKey, Compress: 0x7e}); err == nil {
+		t.Fatal("未注册的压缩算法应返回错误")
+	}
+}
+
+// flateCompressor 测试用的自定义算法，不带zlib头的deflate
+type flateCompressor struct{}
+
+func (flateCompressor) Name() string {
+	return "flate"
+}
+
+func (flateCompressor) Compress(src []byte) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	w, err := flate.NewWriter(buf, flate.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(src); err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	return buf.Bytes(), err
+}
+
+func (flateCompressor) Decompress(src []byte) ([]byte, error) {
+	return io.ReadAll(flate.NewReader(bytes.NewReader(src)))
+}
+
+func TestRegisterCompressor(t *testing.T) {
+	const id CompressType = 0x7f
+	if _, err := getCompressor(id); err != nil {
+		RegisterCompressor(id, flateCompressor{}) // -count 多次运行时已注册
+	}
+	if id.String() != "flate" || CompressType(0x7e).String() != "unknown" {
+		t.Fatalf("名称不一致 %s", id)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("重复注册应panic")
+			}
+		}()
+		RegisterCompressor(CompressGzip, flateCompressor{})
+	}()
+
+	data := bytes.Repeat([]byte("flate "), 100)
+	conf := &packetConf{secret: DefaultSecretKey, cipher: CipherDES, version: PacketV1, compress: id}
+	stream, err := packetEncoder(CommandPut, "name", "abcdefg", data, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet, err := packetDecrypt(stream, len(stream), &packetConf{secret: DefaultSecretKey, cipher: CipherDES})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if packet.Compress != id || !bytes.Equal(packet.Data, data) {
+		t.Fatalf("compress = %s", packet.Compress)
+	}
+}
+
+// TestCipherNoneSmallPayload 不加密且小于压缩阈值的数据引用读缓冲，处理方法中读取时缓冲不能被复用
+func TestCipherNoneSmallPayload(t *testing.T) {
+	network := simnet.New(simnet.Conf{}, 1)
+	got := newReceived()
+	_, c := simPairConf(t, network, func(sConf *ServersConf, cConf *ClientConf) {
+		sConf.Cipher, cConf.Cipher = CipherNone, CipherNone
+	}, func(s *Servers, c *Client) {
+		s.PutHandleFunc("put", func(s *Servers, c *ClientInfo, data []byte) {
+			time.Sleep(time.Millisecond)
+			got.add(data)
+		})
+		s.GetHandleFunc("get", func(s *Servers, param []byte) (int, []byte) {
+			time.Sleep(time.Millisecond)
+			return 0, append([]byte("re:"), param...)
+		})
+	})
+	const n = 50
+	for i := 0; i < n; i++ {
+		c.Put("put", []byte(fmt.Sprintf("data-%02d", i)))
+	}
+	for i := 0; i < 5; i++ {
+		param := fmt.Sprintf("param-%d", i)
+		rse, err := c.Get("get", []byte(param))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(rse) != "re:"+param {
+			t.Fatalf("rse = %s", rse)
+		}
+	}
+	waitFor(t, 5*time.Second, "put 未全部送达", func() bool { return got.len() == n })
+	got.mu.Lock()
+	defer got.mu.Unlock()
+	for i := 0; i < n; i++ {
+		if got.data[fmt.Sprintf("data-%02d", i)] == 0 {
+			t.Fatalf("data-%02d 未收到或被覆盖 %v", i, got.data)
+		}
+	}
+}
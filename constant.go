@@ -1,29 +1,111 @@
 package udp
 
 import (
+	"context"
 	"fmt"
 )
 
 const (
-	SignLetterBytes         = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ-_+=~!@#$%^&*()<>{},.?~"
-	DefaultConnectCode      = "c"
-	DefaultServersName      = "servers"
-	DefaultClientName       = "client"
-	DefaultSecretKey        = "12345678"
-	DefaultSGetTimeOut      = 1000 // 单位 ms
-	DefaultNoticeMaxRetry   = 10   // 通知消息最大重试次数
-	DefaultNoticeRetryTimer = 100  // 重试等待时间 单位ms
-	HeartbeatTime           = 5    // 5s
-	HeartbeatTimeLast       = 6    // 6s
-	ServersTimeWheel        = 2    // 2s servers 时间轮
+	SignLetterBytes              = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ-_+=~!@#$%^&*()<>{},.?~"
+	DefaultConnectCode           = "c"
+	DefaultServersName           = "servers"
+	DefaultClientName            = "client"
+	DefaultSecretKey             = "12345678"
+	DefaultSGetTimeOut           = 1000  // 单位 ms
+	DefaultNoticeMaxRetry        = 10    // 通知消息最大重试次数
+	DefaultNoticeRetryTimer      = 100   // 重试等待时间 单位ms
+	HeartbeatTime                = 5     // 5s
+	HeartbeatTimeLast            = 6     // 6s
+	HeartbeatMaxMiss             = 3     // 连续3个心跳未应答判定servers丢失
+	DefaultReconnectInitialDelay = 1     // 1s 第一次重连前的等待时间
+	DefaultReconnectMaxDelay     = 30    // 30s 重连等待时间的上限
+	DefaultReconnectMultiplier   = 2     // 重连等待时间的倍数
+	DefaultReconnectJitter       = 0.2   // 重连等待时间的随机抖动比例
+	DefaultFailbackTime          = 30    // 30s 连接备用servers时探测首选servers的间隔
+	DefaultConnectRetry          = 500   // Connect 等待时重发连接包的间隔 单位ms
+	DefaultWorkers               = 64    // 处理数据包的协程数
+	DefaultQueueSize             = 1024  // 等待处理的数据包队列长度
+	DefaultBatchSize             = 64    // 批量收发时一次系统调用的最大包数
+	DefaultBatchLinger           = 0     // 批量发送时等待凑满一批的最长时间 单位µs，默认不等待
+	ServersTimeWheel             = 2     // 2s servers 时间轮
+	PacketDataMax                = 540   // 单包data的最大字节数(加密后)
+	FragmentSize                 = 480   // 分片时每片(压缩后)数据的字节数，保证加上分片头并加密后不超过 PacketDataMax
+	FragmentMaxTotal             = 4096  // 单个消息最多的分片数
+	FragmentTimeOut              = 10    // 10s 分片未到齐的消息超时丢弃
+	FragmentMaxMsgPerAddr        = 64    // 每个来源同时重组中的消息数
+	FragmentMaxMsg               = 4096  // 同时重组中的消息总数
+	DefaultBacklogMax            = 10000 // 内存中最大积压数据包条数
+	DefaultBacklogMin            = 5000  // 持久化加载的最小量级
+	DefaultBacklogDir            = "."   // 积压数据持久化的目录
+	DefaultShutdownTimeOut       = 5     // 5s 收到退出信号时等待优雅关闭的时间
+	NameMaxLen                   = 64    // client与servers名称的最大字节数，v0包头为7
+	DefaultCompressMin           = 64    // 小于该字节数的数据不压缩
+)
+
+// 分片重组占用内存的上限
+const (
+	DataMax                 = 8 << 20  // 单个消息(解压后)的最大字节数
+	FragmentMaxBytesPerAddr = 4 << 20  // 每个来源重组中的分片字节数
+	FragmentMaxBytes        = 64 << 20 // 重组中的分片总字节数
 )
 
 // err
 var (
-	ErrNmeLengthAbove  = fmt.Errorf("名字不能超过7个长度")
-	ErrDataLengthAbove = fmt.Errorf("数据大于 540个字节, 建议拆分")
+	ErrNmeLengthAbove  = fmt.Errorf("名字不能超过64个字节，使用v0包头时不能超过7个字节")
+	ErrNameInvalid     = fmt.Errorf("名字必须是有效的UTF-8字符串")
+	ErrDataLengthAbove = fmt.Errorf("数据大于 %d 个字节或压缩后大于 %d 个字节(%d 个分片), 无法发送", DataMax, FragmentMaxTotal*FragmentSize, FragmentMaxTotal)
 	ErrNonePacket      = fmt.Errorf("空包")
-	ErrSGetTimeOut     = func(label, name, ip string) error {
+	ErrFragment        = fmt.Errorf("错误的分片包")
+	ErrFragmentLimit   = fmt.Errorf("重组中的分片消息过多或占用的内存超过上限")
+	ErrPacketAuth      = fmt.Errorf("数据包完整性校验失败")
+	ErrPacketHead      = fmt.Errorf("错误的包头")
+	ErrPacketChecksum  = fmt.Errorf("数据包校验和错误")
+	ErrPacketVersion   = func(version uint8) error {
+		return fmt.Errorf("不支持的包头版本 version:%d", version)
+	}
+	ErrPacketDataAbove = func(n, max int) error {
+		return fmt.Errorf("单包数据 %d 个字节, 大于上限 %d 个字节", n, max)
+	}
+	ErrDecompressAbove = fmt.Errorf("数据解压后大于 %d 个字节", DataMax)
+	ErrWALRecord       = fmt.Errorf("积压日志记录损坏")
+	ErrPutSign         = fmt.Errorf("put 签名认证失败")
+	ErrPutTimeOut      = fmt.Errorf("put 等待服务端确认超时: %w", context.DeadlineExceeded)
+	ErrPutCanceled     = fmt.Errorf("put 等待服务端确认被取消: %w", context.Canceled)
+	ErrServersClosed   = fmt.Errorf("servers 已关闭")
+	ErrConnectCode     = fmt.Errorf("连接code不正确")
+	ErrConnectSecret   = fmt.Errorf("无法解密servers的数据包，秘钥或加密套件与servers不一致")
+	ErrConnectTimeOut  = fmt.Errorf("连接servers超时")
+	ErrConnectCanceled = fmt.Errorf("连接servers被取消")
+	ErrNoneServers     = fmt.Errorf("servers地址列表为空")
+	ErrSignCheck       = fmt.Errorf("签名认证失败")
+	ErrUnknownCommand  = func(cmd CommandCode) error {
+		return fmt.Errorf("未知指令 command:%d", cmd)
+	}
+	ErrClientClosed    = fmt.Errorf("client 已关闭")
+	ErrConnectRejected = func(state int) error {
+		return fmt.Errorf("servers拒绝连接 state:%d", state)
+	}
+	ErrServersHost = func(host string, err error) error {
+		return fmt.Errorf("错误的servers地址 host:%s err:%v", host, err)
+	}
+	ErrCipherSuite = func(suite CipherSuite) error {
+		return fmt.Errorf("不接受的加密套件 cipher:%s", suite)
+	}
+	ErrCodec = func(id CodecType) error {
+		return fmt.Errorf("未注册的编码 codec:%d", id)
+	}
+	ErrCodecType = func(typ string) error {
+		return fmt.Errorf("编码不支持的类型 type:%s", typ)
+	}
+	ErrCompress = func(id CompressType) error {
+		return fmt.Errorf("未注册的压缩算法 compress:%d", id)
+	}
+	ErrDecode = func(label string, err error) error {
+		return fmt.Errorf("数据解码失败 label:%s err:%v", label, err)
+	}
+	ErrCodecValue  = fmt.Errorf("编码的值不能为nil，解码需要传入非nil的指针")
+	ErrCodecData   = fmt.Errorf("解码失败，数据已损坏或与结构不一致")
+	ErrSGetTimeOut = func(label, name, ip string) error {
 		return fmt.Errorf("请求客户端 FuncLabel:%s | name:%s | IP:%s 超时", label, name, ip)
 	}
 	ErrNotFondClient = func(name string) error {
@@ -35,7 +117,13 @@ var (
 	PanicPutHandleFuncExist = func(label string) {
 		panic(fmt.Sprintf("put handle func label:%s is exist.", label))
 	}
-	ErrServersSecretKey = fmt.Errorf("秘钥的长度只能为8，并且与Client端统一")
+	PanicCodecExist = func(id CodecType) {
+		panic(fmt.Sprintf("codec id:%d is exist.", id))
+	}
+	PanicCompressExist = func(id CompressType) {
+		panic(fmt.Sprintf("compress id:%d is exist.", id))
+	}
+	ErrServersSecretKey = fmt.Errorf("秘钥的长度只能为8(加密套件为CipherAESGCM时也可以为32)，并且与Client端统一")
 	ErrClientNameErr    = fmt.Errorf("client name 不能含特殊字符 @")
-	ErrClientSecretKey  = fmt.Errorf("秘钥的长度只能为8，并且与Servers端统一")
+	ErrClientSecretKey  = fmt.Errorf("秘钥的长度只能为8(加密套件为CipherAESGCM时也可以为32)，并且与Servers端统一")
 )
@@ -0,0 +1,127 @@
+package udp
+
+import (
+	"net"
+	"time"
+)
+
+/*
+
+Failover 多servers故障转移
+ClientConf.Servers 为有序的servers地址列表，第一个为首选servers
+1. 连续 HeartbeatMaxMiss 个心跳未应答判定当前servers丢失，重连时依次切换到下一个servers
+2. 连接到备用servers后每隔 ClientConf.Failback 向首选servers发送连接包探测，收到首选servers的连接应答后切换回首选
+3. 地址支持域名，每次重连与探测前重新解析，servers迁移IP后可以重新连接
+4. 只处理当前servers的数据包，签名与积压数据的重传都针对当前servers
+
+*/
+
+// resolveUDPAddr 解析地址，测试时替换以模拟域名解析的结果变化
+var resolveUDPAddr = net.ResolveUDPAddr
+
+// resolveServersHost 解析servers地址 host:port，支持域名与IPv6 如 collector.local:12345, [::1]:12345
+func resolveServersHost(host string) (*net.UDPAddr, error) {
+	addr, err := resolveUDPAddr("udp", host)
+	if err != nil {
+		return nil, ErrServersHost(host, err)
+	}
+	return addr, nil
+}
+
+// resolveServer 重新解析第i个servers的地址，域名解析的结果变化时更新
+func (c *Client) resolveServer(i int) {
+	if !c.resolve {
+		return
+	}
+	addr, err := resolveServersHost(c.hosts[i])
+	if err != nil {
+		Error(err)
+		return
+	}
+	c.serverLock.Lock()
+	defer c.serverLock.Unlock()
+	if sameAddr(c.servers[i], addr) {
+		return
+	}
+	InfoF("servers %s 的地址变化 %s -> %s", c.hosts[i], c.servers[i], addr)
+	c.servers[i] = addr
+	if c.current == i {
+		c.SConn = addr
+	}
+}
+
+// currentServer 当前servers的序号与地址
+func (c *Client) currentServer() (int, net.Addr) {
+	c.serverLock.RLock()
+	defer c.serverLock.RUnlock()
+	return c.current, c.servers[c.current]
+}
+
+// serverIndex 地址在servers列表中的序号，不在列表中返回-1
+func (c *Client) serverIndex(addr net.Addr) int {
+	c.serverLock.RLock()
+	defer c.serverLock.RUnlock()
+	for i, v := range c.servers {
+		if sameAddr(v, addr) {
+			return i
+		}
+	}
+	return -1
+}
+
+// useServer 切换当前servers
+func (c *Client) useServer(i int) {
+	c.serverLock.Lock()
+	defer c.serverLock.Unlock()
+	if c.current == i {
+		return
+	}
+	InfoF("切换servers %s -> %s", c.servers[c.current], c.servers[i])
+	c.current = i
+	c.SConn = c.servers[i]
+	c.ServersHost = c.hosts[i]
+}
+
+// failover 切换到下一个servers
+func (c *Client) failover() {
+	if len(c.servers) < 2 {
+		return
+	}
+	i, _ := c.currentServer()
+	c.useServer((i + 1) % len(c.servers))
+}
+
+// accept 是否接受来自servers列表中第i个servers的连接应答
+// 当前servers的应答总是接受，优先级更高的servers的应答表示回切探测成功
+func (c *Client) acceptServer(i int) bool {
+	current, _ := c.currentServer()
+	if i > current {
+		return false
+	}
+	c.useServer(i)
+	return true
+}
+
+// failbackProbe 连接备用servers时，每隔 failback 向首选servers发送连接包
+func (c *Client) failbackProbe() {
+	if c.failback <= 0 || !c.online() {
+		return
+	}
+	if i, _ := c.currentServer(); i == 0 {
+		return
+	}
+	if time.Since(c.lastProbe) < c.failback {
+		return
+	}
+	c.lastProbe = time.Now()
+	c.resolveServer(0)
+	data, err := packetEncoder(CommandConnect, c.name, c.getSign(), []byte(c.connectCode), c.packetConf())
+	if err != nil {
+		Error(err)
+		return
+	}
+	c.serverLock.RLock()
+	primary := c.servers[0]
+	c.serverLock.RUnlock()
+	c.writeTo(data, primary)
+}
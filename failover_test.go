@@ -0,0 +1,111 @@
+package udp
+
+import (
+	"net"
+	"testing"
+
+	"github.com/mangenotwork/udp_comm/simnet"
+)
+
+func TestSameAddr(t *testing.T) {
+	udp := func(s string) net.Addr {
+		addr, err := net.ResolveUDPAddr("udp", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return addr
+	}
+	cases := []struct {
+		a, b net.Addr
+		want bool
+	}{
+		{udp("127.0.0.1:9000"), udp("127.0.0.1:9000"), true},
+		{udp("127.0.0.1:9000"), udp("127.0.0.1:9001"), false},
+		{udp("127.0.0.1:9000"), udp("[::ffff:127.0.0.1]:9000"), true},
+		{udp("[::ffff:10.0.0.1]:9000"), udp("10.0.0.2:9000"), false},
+		{udp("[::1]:9000"), udp("[0:0:0:0:0:0:0:1]:9000"), true},
+		{udp("[::1]:9000"), udp("127.0.0.1:9000"), false},
+		{udp("[fe80::1%lo]:9000"), udp("[fe80::1%lo]:9000"), true},
+		{udp("[fe80::1%lo]:9000"), udp("[fe80::1%eth0]:9000"), false},
+		{udp("[fe80::1%lo]:9000"), udp("[fe80::1]:9000"), false},
+		{simnet.Addr("servers"), simnet.Addr("servers"), true},
+		{simnet.Addr("servers"), udp("127.0.0.1:9000"), false},
+	}
+	for _, v := range cases {
+		if got := sameAddr(v.a, v.b); got != v.want || sameAddr(v.b, v.a) != v.want {
+			t.Errorf("sameAddr(%s, %s) = %v, 应为 %v", v.a, v.b, got, v.want)
+		}
+	}
+}
+
+// TestResolveServer 重连前重新解析servers地址，域名解析的结果变化时更新
+func TestResolveServer(t *testing.T) {
+	ips := map[string]string{}
+	resolveUDPAddr = func(network, address string) (*net.UDPAddr, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		if ip, ok := ips[host]; ok {
+			host = ip
+		}
+		return net.ResolveUDPAddr(network, net.JoinHostPort(host, port))
+	}
+	defer func() {
+		resolveUDPAddr = net.ResolveUDPAddr
+	}()
+
+	cases := []struct {
+		name    string
+		host    string
+		ip      string // 重新解析前域名对应的新地址，为空时不变
+		current int    // 当前servers的序号
+		want    string
+	}{
+		{"域名对应新的IP", "collector.test:9000", "10.0.0.2", 0, "10.0.0.2:9000"},
+		{"域名对应IPv6", "collector.test:9000", "fd00::2", 0, "[fd00::2]:9000"},
+		{"非当前servers", "collector.test:9000", "10.0.0.3", 1, "10.0.0.3:9000"},
+		{"地址不变", "collector.test:9000", "", 0, "10.0.0.1:9000"},
+		{"IPv6字面量带zone", "[fe80::1%lo]:9000", "", 0, "[fe80::1%lo]:9000"},
+		{"映射到IPv6的IPv4", "[::ffff:10.0.0.1]:9000", "", 0, "10.0.0.1:9000"},
+	}
+	for _, v := range cases {
+		ips["collector.test"] = "10.0.0.1"
+		first, err := resolveServersHost(v.host)
+		if err != nil {
+			t.Fatalf("%s: %v", v.name, err)
+		}
+		backup, _ := resolveServersHost("127.0.0.1:9001")
+		c := &Client{
+			hosts:   []string{v.host, "127.0.0.1:9001"},
+			servers: []net.Addr{first, backup},
+			resolve: true,
+			current: v.current,
+			SConn:   []net.Addr{first, backup}[v.current],
+		}
+		if v.ip != "" {
+			ips["collector.test"] = v.ip
+		}
+		c.resolveServer(0)
+		if got := c.servers[0].String(); got != v.want {
+			t.Errorf("%s: 解析为 %s, 应为 %s", v.name, got, v.want)
+		}
+		want := c.servers[v.current]
+		if !sameAddr(c.SConn, want) {
+			t.Errorf("%s: SConn = %s, 应为 %s", v.name, c.SConn, want)
+		}
+		if i := c.serverIndex(first); (i == 0) != sameAddr(first, c.servers[0]) {
+			t.Errorf("%s: 旧地址 %s 的序号 %d", v.name, first, i)
+		}
+	}
+
+	// NewClientWithConn 传入的地址不重新解析
+	ips["collector.test"] = "10.0.0.1"
+	addr, _ := resolveServersHost("collector.test:9000")
+	c := &Client{hosts: []string{"collector.test:9000"}, servers: []net.Addr{addr}, SConn: addr}
+	ips["collector.test"] = "10.0.0.9"
+	c.resolveServer(0)
+	if c.servers[0].String() != "10.0.0.1:9000" {
+		t.Fatalf("未开启解析时地址变化 %s", c.servers[0])
+	}
+}
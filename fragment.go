@@ -0,0 +1,245 @@
+package udp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+)
+
+/*
+
+Fragment 分片设计
+数据压缩后超过单包上限时，将压缩后的数据拆分为多个 CommandFragment 包，每个分片包的data如下:
+______________________________________________________________________________
+|                |              |              |              |               |
+| 原指令(1字节)   |  消息id(8字节) | 序号(2字节)   | 总数(2字节)   |  分片数据...    |
+|________________|______________|______________|______________|_______________|
+
+v1 分片包包头中的压缩算法指整个消息，分片本身不再压缩；v0 包头没有压缩标志，每个分片包仍然zlib压缩
+
+接收端按 来源地址+消息id 重组，全部分片到齐后解压并还原成原指令的包再交给业务处理，
+超过 FragmentTimeOut 未到齐的消息会被丢弃，每个来源与全部来源同时重组的消息数与字节数有上限，
+解压后的数据不能超过 DataMax 个字节
+
+*/
+
+const fragmentHeadLen = 13
+
+type Fragment struct {
+	Command CommandCode // 原指令
+	Id      int64       // 消息id,同一消息的分片相同
+	Index   uint16      // 分片序号 从0开始
+	Total   uint16      // 分片总数
+	Data    []byte      // 分片数据
+}
+
+func (f *Fragment) encode() []byte {
+	buf := new(bytes.Buffer)
+	_ = binary.Write(buf, binary.BigEndian, f.Command)
+	_ = binary.Write(buf, binary.BigEndian, f.Id)
+	_ = binary.Write(buf, binary.BigEndian, f.Index)
+	_ = binary.Write(buf, binary.BigEndian, f.Total)
+	_, _ = buf.Write(f.Data)
+	return buf.Bytes()
+}
+
+func decodeFragment(data []byte) (*Fragment, error) {
+	if len(data) < fragmentHeadLen {
+		return nil, ErrFragment
+	}
+	f := &Fragment{
+		Command: CommandCode(data[0]),
+		Id:      int64(binary.BigEndian.Uint64(data[1:9])),
+		Index:   binary.BigEndian.Uint16(data[9:11]),
+		Total:   binary.BigEndian.Uint16(data[11:13]),
+		Data:    data[fragmentHeadLen:],
+	}
+	if f.Total == 0 || f.Total > FragmentMaxTotal || f.Index >= f.Total {
+		return nil, ErrFragment
+	}
+	return f, nil
+}
+
+// packetEncoderFragment 封包，数据压缩后超过单包上限时自动拆分为多个分片包
+// 只压缩一次，按加密后的长度估算是否需要分片
+func packetEncoderFragment(cmd CommandCode, name, sign string, data []byte, conf *packetConf) ([][]byte, error) {
+	if len(data) > DataMax {
+		return nil, ErrDataLengthAbove
+	}
+	dCompress, compress, err := packetCompress(conf, data)
+	if err != nil {
+		return nil, err
+	}
+	if encryptedLen(conf.cipher, len(dCompress)) <= PacketDataMax {
+		stream, err := packetEncoderCompressed(cmd, name, sign, dCompress, compress, conf)
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{stream}, nil
+	}
+	total := (len(dCompress) + FragmentSize - 1) / FragmentSize
+	if total > FragmentMaxTotal {
+		return nil, ErrDataLengthAbove
+	}
+	msgId := id()
+	list := make([][]byte, 0, total)
+	for i := 0; i < total; i++ {
+		end := (i + 1) * FragmentSize
+		if end > len(dCompress) {
+			end = len(dCompress)
+		}
+		f := &Fragment{
+			Command: cmd,
+			Id:      msgId,
+			Index:   uint16(i),
+			Total:   uint16(total),
+			Data:    dCompress[i*FragmentSize : end],
+		}
+		var fStream []byte
+		if conf.version == PacketV0 {
+			fStream, err = packetEncoder(CommandFragment, name, sign, f.encode(), conf)
+		} else {
+			fStream, err = packetEncoderCompressed(CommandFragment, name, sign, f.encode(), compress, conf)
+		}
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, fStream)
+	}
+	return list, nil
+}
+
+// fragmentPool 分片重组池
+type fragmentPool struct {
+	mu     sync.Mutex
+	list   map[string]*fragmentMsg // key: 来源地址@消息id
+	counts map[string]int          // 来源地址 -> 重组中的消息数
+	sizes  map[string]int          // 来源地址 -> 重组中的分片字节数
+	size   int                     // 重组中的分片总字节数
+}
+
+type fragmentMsg struct {
+	addr     string
+	command  CommandCode
+	compress CompressType
+	parts    [][]byte
+	received int
+	size     int
+	created  int64
+}
+
+func newFragmentPool() *fragmentPool {
+	return &fragmentPool{
+		list:   make(map[string]*fragmentMsg),
+		counts: make(map[string]int),
+		sizes:  make(map[string]int),
+	}
+}
+
+// add 存入一个分片包，消息的分片全部到齐后返回解压并重组后的包，否则返回nil
+func (p *fragmentPool) add(addr string, packet *Packet) (*Packet, error) {
+	f, err := decodeFragment(packet.Data)
+	if err != nil {
+		return nil, err
+	}
+	data, err := p.put(addr, f, packet.Compress)
+	if data == nil || err != nil {
+		return nil, err
+	}
+	if packet.Compress != CompressNone {
+		compressor, err := getCompressor(packet.Compress)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = decompress(compressor, data, DataMax); err != nil {
+			Error("解压分片消息失败 err: ", err)
+			return nil, err
+		}
+	}
+	return &Packet{
+		Command:  f.Command,
+		Name:     packet.Name,
+		Sign:     packet.Sign,
+		Data:     data,
+		Cipher:   packet.Cipher,
+		Version:  packet.Version,
+		Codec:    packet.Codec,
+		Compress: packet.Compress,
+	}, nil
+}
+
+// put 存入一个分片，全部到齐后返回拼接的数据
+func (p *fragmentPool) put(addr string, f *Fragment, compress CompressType) ([]byte, error) {
+	key := fmt.Sprintf("%s@%d", addr, f.Id)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	msg, ok := p.list[key]
+	if !ok {
+		if p.counts[addr] >= FragmentMaxMsgPerAddr || len(p.list) >= FragmentMaxMsg {
+			return nil, ErrFragmentLimit
+		}
+		msg = &fragmentMsg{
+			addr:     addr,
+			command:  f.Command,
+			compress: compress,
+			parts:    make([][]byte, f.Total),
+			created:  time.Now().Unix(),
+		}
+		p.list[key] = msg
+		p.counts[addr]++
+	}
+	if int(f.Total) != len(msg.parts) || f.Command != msg.command || compress != msg.compress {
+		p.remove(key, msg)
+		return nil, ErrFragment
+	}
+	if msg.parts[f.Index] != nil {
+		// 重复的分片
+		return nil, nil
+	}
+	if n := len(f.Data); p.sizes[addr]+n > FragmentMaxBytesPerAddr || p.size+n > FragmentMaxBytes {
+		if msg.received == 0 {
+			p.remove(key, msg)
+		}
+		return nil, ErrFragmentLimit
+	}
+	msg.parts[f.Index] = append([]byte{}, f.Data...)
+	msg.received++
+	msg.size += len(f.Data)
+	p.sizes[addr] += len(f.Data)
+	p.size += len(f.Data)
+	if msg.received < len(msg.parts) {
+		return nil, nil
+	}
+	p.remove(key, msg)
+	data := make([]byte, 0, msg.size)
+	for _, v := range msg.parts {
+		data = append(data, v...)
+	}
+	return data, nil
+}
+
+func (p *fragmentPool) remove(key string, msg *fragmentMsg) {
+	delete(p.list, key)
+	if p.counts[msg.addr]--; p.counts[msg.addr] <= 0 {
+		delete(p.counts, msg.addr)
+	}
+	p.size -= msg.size
+	if p.sizes[msg.addr] -= msg.size; p.sizes[msg.addr] <= 0 {
+		delete(p.sizes, msg.addr)
+	}
+}
+
+// clean 丢弃超时未到齐的消息
+func (p *fragmentPool) clean() {
+	t := time.Now().Unix()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for k, v := range p.list {
+		if t-v.created > FragmentTimeOut {
+			ErrorF("分片消息超时丢弃 key:%s 已收到:%d/%d", k, v.received, len(v.parts))
+			p.remove(k, v)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package udp
+
+import (
+	"bytes"
+	"crypto/rand"
+	mrand "math/rand"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mangenotwork/udp_comm/simnet"
+)
+
+// fragmentPackets 封包并逐个解包，返回分片包
+func fragmentPackets(t *testing.T, data []byte, conf *packetConf) []*Packet {
+	t.Helper()
+	streams, err := packetEncoderFragment(CommandPut, "name", "abcdefg", data, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := make([]*Packet, 0, len(streams))
+	for _, stream := range streams {
+		head, err := packetHeader(stream, len(stream))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := len(stream) - head.size; n > PacketDataMax {
+			t.Fatalf("分片包data %d 个字节", n)
+		}
+		packet, err := packetDecode(head, stream, conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		list = append(list, packet)
+	}
+	return list
+}
+
+func TestFragmentReassemble(t *testing.T) {
+	random := make([]byte, 5000)
+	_, _ = rand.Read(random)
+	text := bytes.Repeat([]byte("fragment "), 2000)
+	for _, version := range []uint8{PacketV0, PacketV1} {
+		for _, cipher := range []CipherSuite{CipherDES, CipherAESGCM, CipherNone} {
+			conf := &packetConf{secret: DefaultSecretKey, cipher: cipher, version: version, compressMin: DefaultCompressMin}
+			// 压缩后不超过单包上限的数据不分片
+			if list := fragmentPackets(t, text, conf); len(list) != 1 || !bytes.Equal(list[0].Data, text) {
+				t.Fatalf("v%d %s: 可压缩的数据分为 %d 片", version, cipher, len(list))
+			}
+
+			list := fragmentPackets(t, random, conf)
+			if len(list) < 2 {
+				t.Fatalf("v%d %s: 分为 %d 片", version, cipher, len(list))
+			}
+			// 乱序并重复发送，最后一个分片到达时重组完成
+			perm := mrand.Perm(len(list))
+			order := append([]int{perm[0], perm[0]}, perm[1:len(perm)-1]...)
+			order = append(order, perm[1], perm[len(perm)-1])
+			pool := newFragmentPool()
+			var got *Packet
+			for i, idx := range order {
+				packet, err := pool.add("addr", list[idx])
+				if err != nil {
+					t.Fatalf("v%d %s: %v", version, cipher, err)
+				}
+				if packet != nil {
+					if got != nil || i != len(order)-1 {
+						t.Fatalf("v%d %s: 第%d个分片重组完成", version, cipher, i)
+					}
+					got = packet
+				}
+			}
+			if got == nil || got.Command != CommandPut || !bytes.Equal(got.Data, random) {
+				t.Fatalf("v%d %s: 重组结果不一致", version, cipher)
+			}
+			if len(pool.list) != 0 || len(pool.counts) != 0 {
+				t.Fatalf("v%d %s: 重组后未清理 %d %d", version, cipher, len(pool.list), len(pool.counts))
+			}
+		}
+	}
+}
+
+func TestFragmentClean(t *testing.T) {
+	random := make([]byte, 2000)
+	_, _ = rand.Read(random)
+	list := fragmentPackets(t, random, &packetConf{secret: DefaultSecretKey, cipher: CipherAESGCM, version: PacketV1})
+	pool := newFragmentPool()
+	if packet, err := pool.add("addr", list[0]); packet != nil || err != nil {
+		t.Fatalf("packet = %v err = %v", packet, err)
+	}
+	pool.clean()
+	if len(pool.list) != 1 {
+		t.Fatal("未超时的消息不应丢弃")
+	}
+	for _, msg := range pool.list {
+		msg.created -= FragmentTimeOut + 1
+	}
+	pool.clean()
+	if len(pool.list) != 0 || len(pool.counts) != 0 {
+		t.Fatal("超时的消息应丢弃")
+	}
+	// 超时丢弃后剩余的分片不能重组出消息
+	for _, packet := range list[1:] {
+		if got, err := pool.add("addr", packet); got != nil || err != nil {
+			t.Fatalf("packet = %v err = %v", got, err)
+		}
+	}
+}
+
+func TestFragmentLimit(t *testing.T) {
+	pool := newFragmentPool()
+	add := func(addr string, id int64) error {
+		f := &Fragment{Command: CommandPut, Id: id, Index: 0, Total: 2, Data: []byte("data")}
+		_, err := pool.add(addr, &Packet{Command: CommandFragment, Data: f.encode(), Compress: CompressNone})
+		return err
+	}
+	for i := 0; i < FragmentMaxMsgPerAddr; i++ {
+		if err := add("a", int64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := add("a", FragmentMaxMsgPerAddr); err != ErrFragmentLimit {
+		t.Fatalf("超过单个来源的上限 err = %v", err)
+	}
+	// 已有消息的分片不受限制
+	if err := add("a", 0); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; len(pool.list) < FragmentMaxMsg; i++ {
+		if err := add(string(rune('b'+i/FragmentMaxMsgPerAddr)), int64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := add("z", 0); err != ErrFragmentLimit {
+		t.Fatalf("超过总上限 err = %v", err)
+	}
+}
+
+func TestFragmentBytesLimit(t *testing.T) {
+	pool := newFragmentPool()
+	part := make([]byte, 1<<20)
+	add := func(addr string, index uint16) error {
+		f := &Fragment{Command: CommandPut, Id: 1, Index: index, Total: 128, Data: part}
+		_, err := pool.add(addr, &Packet{Command: CommandFragment, Data: f.encode(), Compress: CompressNone})
+		return err
+	}
+	perAddr := FragmentMaxBytesPerAddr / len(part)
+	for i := 0; i < perAddr; i++ {
+		if err := add("a", uint16(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := add("a", uint16(perAddr)); err != ErrFragmentLimit {
+		t.Fatalf("超过单个来源的字节上限 err = %v", err)
+	}
+	for i := 1; pool.size < FragmentMaxBytes; i++ {
+		for j := 0; j < perAddr; j++ {
+			if err := add(string(rune('a'+i)), uint16(j)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// 被拒绝的第一个分片不会留下空的消息
+	if err := add("z", 0); err != ErrFragmentLimit {
+		t.Fatalf("超过总字节上限 err = %v", err)
+	}
+	if _, ok := pool.counts["z"]; ok {
+		t.Fatal("被拒绝的消息仍在重组池中")
+	}
+	for _, msg := range pool.list {
+		msg.created -= FragmentTimeOut + 1
+	}
+	pool.clean()
+	if pool.size != 0 || len(pool.sizes) != 0 {
+		t.Fatalf("清理后仍占用 %d 个字节", pool.size)
+	}
+}
+
+// TestFragmentBomb 重组后解压超过 DataMax 的消息被丢弃
+func TestFragmentBomb(t *testing.T) {
+	for _, compress := range []CompressType{CompressZlib, CompressGzip} {
+		compressor, _ := getCompressor(compress)
+		bomb, err := compressor.Compress(make([]byte, DataMax+1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = decompress(compressor, bomb, DataMax); err != ErrDecompressAbove {
+			t.Fatalf("%s: err = %v", compress, err)
+		}
+		total := (len(bomb) + FragmentSize - 1) / FragmentSize
+		pool := newFragmentPool()
+		for i := 0; i < total; i++ {
+			end := (i + 1) * FragmentSize
+			if end > len(bomb) {
+				end = len(bomb)
+			}
+			f := &Fragment{Command: CommandPut, Id: 1, Index: uint16(i), Total: uint16(total), Data: bomb[i*FragmentSize : end]}
+			packet, err := pool.add("addr", &Packet{Command: CommandFragment, Data: f.encode(), Compress: compress})
+			if i < total-1 && (packet != nil || err != nil) {
+				t.Fatalf("%s: packet = %v err = %v", compress, packet, err)
+			}
+			if i == total-1 && (packet != nil || err != ErrDecompressAbove) {
+				t.Fatalf("%s: 重组后 err = %v", compress, err)
+			}
+		}
+	}
+	// 未实现 limitDecompressor 的算法解压后检查长度
+	bomb, _ := flateCompressor{}.Compress(make([]byte, DataMax+1))
+	if _, err := decompress(flateCompressor{}, bomb, DataMax); err != ErrDecompressAbove {
+		t.Fatalf("flate: err = %v", err)
+	}
+}
+
+// TestFragmentSign 签名错误的分片包不存入重组池
+func TestFragmentSign(t *testing.T) {
+	network := simnet.New(simnet.Conf{}, 1)
+	var failures int32
+	s, _ := simPair(t, network, func(s *Servers, c *Client) {
+		s.OnAuthFailure(func(s *Servers, c *ClientInfo, err error) {
+			if err == ErrSignCheck {
+				atomic.AddInt32(&failures, 1)
+			}
+		})
+	})
+	conn, err := network.Listen("attacker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	random := make([]byte, 2000)
+	_, _ = rand.Read(random)
+	conf := &packetConf{secret: DefaultSecretKey, cipher: CipherDES, version: PacketV1}
+	streams, err := packetEncoderFragment(CommandPut, "attacker", "abcdefg", random, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stream := range streams[:len(streams)-1] {
+		if _, err := conn.WriteTo(stream, s.conns[0].LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for int(atomic.LoadInt32(&failures)) < len(streams)-1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&failures); int(n) != len(streams)-1 {
+		t.Fatalf("签名认证失败 %d 次, 应为 %d 次", n, len(streams)-1)
+	}
+	s.fragment.mu.Lock()
+	defer s.fragment.mu.Unlock()
+	if len(s.fragment.list) != 0 || s.fragment.size != 0 {
+		t.Fatalf("签名错误的分片存入了重组池 %d", len(s.fragment.list))
+	}
+}
+
+func TestFragmentTooLarge(t *testing.T) {
+	conf := &packetConf{secret: DefaultSecretKey, cipher: CipherNone, version: PacketV1, compress: CompressNone}
+	data := make([]byte, FragmentMaxTotal*FragmentSize+1)
+	if _, err := packetEncoderFragment(CommandPut, "name", "abcdefg", data, conf); err != ErrDataLengthAbove {
+		t.Fatalf("err = %v", err)
+	}
+	// 可压缩的数据解压后也不能超过 DataMax
+	conf.compress = CompressZlib
+	if _, err := packetEncoderFragment(CommandPut, "name", "abcdefg", make([]byte, DataMax+1), conf); err != ErrDataLengthAbove {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+// TestFragmentLarge 在模拟网络上发送超过单包上限的数据
+func TestFragmentLarge(t *testing.T) {
+	network := simnet.New(simnet.Conf{}, 1)
+	body := make([]byte, 20*PacketDataMax)
+	_, _ = rand.Read(body)
+	got := make(chan []byte, 1)
+	_, c := simPair(t, network, func(s *Servers, c *Client) {
+		s.PutHandleFunc("put", func(s *Servers, c *ClientInfo, data []byte) {
+			select {
+			case got <- data:
+			default:
+			}
+		})
+		s.GetHandleFunc("get", func(s *Servers, param []byte) (int, []byte) {
+			return 0, append(param, body...)
+		})
+	})
+	c.Put("put", body)
+	select {
+	case data := <-got:
+		if !bytes.Equal(data, body) {
+			t.Fatal("put 数据不一致")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("put 未送达")
+	}
+	rse, err := c.Get("get", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rse, append(append([]byte{}, body...), body...)) {
+		t.Fatal("get 应答不一致")
+	}
+}
@@ -1,18 +1,66 @@
 package udp
 
-import "sync"
+import (
+	"context"
+	"sync"
+	"time"
+)
 
 type GetData struct {
-	Label    string    // 标签，用于区分当前数据处理的方法
-	Id       int64     // 唯一id
-	Param    []byte    // 传过来的数据
-	ctxChan  chan bool // 确认接受到消息
-	Response []byte    // 返回的数据
+	Label    string         // 标签，用于区分当前数据处理的方法
+	Id       int64          // 唯一id
+	Param    []byte         // 传过来的数据
+	Timeout  int64          // 请求方剩余的超时时间 单位ms, 0表示不限制，处理方据此生成handler的ctx
+	ctxChan  chan getResult // 收到的应答
+	Response []byte         // 返回的数据
 	Err      error
 }
 
-type ServersGetFunc map[string]func(s *Servers, param []byte) (int, []byte)
+// getResult 应答通过chan交给等待中的请求，重复的应答不会修改请求方正在读取的数据
+type getResult struct {
+	state    int    // 应答的状态码
+	response []byte // 返回的数据
+}
+
+type ServersGetFunc map[string]func(ctx context.Context, s *Servers, param []byte) (int, []byte)
 
-type ClientGetFunc map[string]func(c *Client, param []byte) (int, []byte)
+type ClientGetFunc map[string]func(ctx context.Context, c *Client, param []byte) (int, []byte)
 
 var GetDataMap sync.Map
+
+func newGetData(ctx context.Context, funcLabel string, param []byte) *GetData {
+	getData := &GetData{
+		Label:    funcLabel,
+		Id:       id(),
+		Param:    param,
+		ctxChan:  make(chan getResult, 1),
+		Response: make([]byte, 0),
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		getData.Timeout = time.Until(deadline).Milliseconds()
+		if getData.Timeout < 1 {
+			getData.Timeout = 1
+		}
+	}
+	return getData
+}
+
+// context 根据请求方剩余的超时时间生成handler使用的ctx
+func (g *GetData) context() (context.Context, context.CancelFunc) {
+	if g.Timeout > 0 {
+		return context.WithTimeout(context.Background(), time.Duration(g.Timeout)*time.Millisecond)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// getDataDone 收到应答，通知等待中的请求，请求已超时或取消时丢弃
+func getDataDone(getId int64, state int, response []byte) {
+	getF, ok := GetDataMap.Load(getId)
+	if !ok || getF == nil {
+		return
+	}
+	select {
+	case getF.(*GetData).ctxChan <- getResult{state: state, response: response}:
+	default:
+	}
+}
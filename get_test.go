@@ -0,0 +1,70 @@
+package udp
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mangenotwork/udp_comm/simnet"
+)
+
+// TestGetDataDoneDuplicate 重复的应答只交付第一个，不修改请求方已收到的数据
+func TestGetDataDoneDuplicate(t *testing.T) {
+	getData := newGetData(context.Background(), "get", nil)
+	GetDataMap.Store(getData.Id, getData)
+	defer GetDataMap.Delete(getData.Id)
+
+	getDataDone(getData.Id, ReplyStateSuccess, []byte("first"))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			getDataDone(getData.Id, ReplyStateCustom, []byte("duplicate"))
+		}()
+	}
+	res := <-getData.ctxChan
+	wg.Wait()
+	if res.state != ReplyStateSuccess || string(res.response) != "first" {
+		t.Fatalf("state = %d response = %s", res.state, res.response)
+	}
+	if len(getData.Response) != 0 {
+		t.Fatalf("GetData 被修改 %s", getData.Response)
+	}
+}
+
+// TestGetReplyState 对端的方法返回非0状态码时 Get 返回 *ReplyError
+func TestGetReplyState(t *testing.T) {
+	network := simnet.New(simnet.Conf{}, 1)
+	s, c := simPair(t, network, func(s *Servers, c *Client) {
+		s.GetHandleFunc("get", func(s *Servers, param []byte) (int, []byte) {
+			if len(param) == 0 {
+				return ReplyStateCustom, []byte("参数为空")
+			}
+			return ReplyStateSuccess, param
+		})
+		c.GetHandleFunc("get", func(c *Client, param []byte) (int, []byte) {
+			return ReplyStateCustom, []byte("c端失败")
+		})
+	})
+	rse, err := c.Get("get", []byte("ok"))
+	if err != nil || string(rse) != "ok" {
+		t.Fatalf("rse = %s err = %v", rse, err)
+	}
+
+	var replyErr *ReplyError
+	rse, err = c.Get("get", nil)
+	if !errors.As(err, &replyErr) || replyErr.StateCode != ReplyStateCustom || replyErr.Msg != "参数为空" || rse != nil {
+		t.Fatalf("rse = %s err = %v", rse, err)
+	}
+	waitFor(t, 2*time.Second, "client 未上线", func() bool {
+		_, ok := s.GetClientConn("sim")
+		return ok
+	})
+	rse, err = s.Get("get", "sim", nil)
+	if !errors.As(err, &replyErr) || replyErr.StateCode != ReplyStateCustom || replyErr.Msg != "c端失败" || rse != nil {
+		t.Fatalf("rse = %s err = %v", rse, err)
+	}
+}
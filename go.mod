@@ -1,3 +1,8 @@
 module github.com/mangenotwork/udp_comm
 
 go 1.19
+
+require (
+	golang.org/x/net v0.17.0
+	golang.org/x/sys v0.13.0
+)
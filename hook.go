@@ -0,0 +1,74 @@
+package udp
+
+import (
+	"sync"
+)
+
+// serversHook servers端连接生命周期的回调，回调在处理数据包或时间轮的协程中同步执行，应尽快返回
+type serversHook struct {
+	mu            sync.RWMutex
+	connect       []func(s *Servers, c *ClientInfo)
+	reconnect     []func(s *Servers, c *ClientInfo)
+	offline       []func(s *Servers, c *ClientInfo)
+	authFailure   []func(s *Servers, c *ClientInfo, err error)
+	unknownPacket []func(s *Servers, c *ClientInfo, err error)
+}
+
+// OnClientConnect c端首次连接
+func (s *Servers) OnClientConnect(fn func(s *Servers, c *ClientInfo)) {
+	s.hook.mu.Lock()
+	defer s.hook.mu.Unlock()
+	s.hook.connect = append(s.hook.connect, fn)
+}
+
+// OnClientReconnect 离线后重新连接，或同一 name+ip 换了端口重新连接
+func (s *Servers) OnClientReconnect(fn func(s *Servers, c *ClientInfo)) {
+	s.hook.mu.Lock()
+	defer s.hook.mu.Unlock()
+	s.hook.reconnect = append(s.hook.reconnect, fn)
+}
+
+// OnClientOffline c端超时未发送心跳被判定离线，或调用了 ClientDiscard
+func (s *Servers) OnClientOffline(fn func(s *Servers, c *ClientInfo)) {
+	s.hook.mu.Lock()
+	defer s.hook.mu.Unlock()
+	s.hook.offline = append(s.hook.offline, fn)
+}
+
+// OnAuthFailure 连接code不正确或签名认证失败
+func (s *Servers) OnAuthFailure(fn func(s *Servers, c *ClientInfo, err error)) {
+	s.hook.mu.Lock()
+	defer s.hook.mu.Unlock()
+	s.hook.authFailure = append(s.hook.authFailure, fn)
+}
+
+// OnUnknownPacket 无法解包或未知指令的数据包，无法解包时 ClientInfo.Name 为空
+func (s *Servers) OnUnknownPacket(fn func(s *Servers, c *ClientInfo, err error)) {
+	s.hook.mu.Lock()
+	defer s.hook.mu.Unlock()
+	s.hook.unknownPacket = append(s.hook.unknownPacket, fn)
+}
+
+func (s *Servers) fireClient(list *[]func(s *Servers, c *ClientInfo), c *ClientInfo) {
+	s.hook.mu.RLock()
+	fns := *list
+	s.hook.mu.RUnlock()
+	for _, fn := range fns {
+		fn(s, c)
+	}
+}
+
+func (s *Servers) fireClientErr(list *[]func(s *Servers, c *ClientInfo, err error), c *ClientInfo, err error) {
+	s.hook.mu.RLock()
+	fns := *list
+	s.hook.mu.RUnlock()
+	for _, fn := range fns {
+		fn(s, c, err)
+	}
+}
+
+// clientHook client端的回调
+type clientHook struct {
+	mu          sync.RWMutex
+	stateChange []func(c *Client, old, new ClientState)
+}
@@ -14,3 +14,15 @@ type NoticeData struct {
 var NoticeDataMap sync.Map
 
 type ClientNoticeFunc map[string]func(c *Client, data []byte)
+
+// noticeDataDone 收到c端的确认，通知等待中的通知消息，重复的确认或已超时时丢弃
+func noticeDataDone(noticeId int64) {
+	v, ok := NoticeDataMap.Load(noticeId)
+	if !ok || v == nil {
+		return
+	}
+	select {
+	case v.(*NoticeData).ctxChan <- true:
+	default:
+	}
+}
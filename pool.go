@@ -0,0 +1,121 @@
+package udp
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// OverflowPolicy 处理队列满时的策略
+type OverflowPolicy int
+
+const (
+	OverflowDrop  OverflowPolicy = iota // 丢弃新收到的包，默认
+	OverflowBlock                       // 阻塞读取直到队列有空位(背压)，由内核缓冲区继续接收
+)
+
+const packetBufSize = 1500 // 单个数据包的读取缓冲区大小
+
+// packetBufPool 读取数据包的缓冲区，每个包使用独立的缓冲区，处理完成后放回
+var packetBufPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, packetBufSize)
+		return &b
+	},
+}
+
+// inPacket 收到的待处理数据包
+type inPacket struct {
+	buf  *[]byte
+	n    int
+	addr net.Addr
+	conn net.PacketConn // 收到该包的socket
+}
+
+func (p *inPacket) data() []byte {
+	return *p.buf
+}
+
+// release 缓冲区放回池中，之后不能再访问 data
+func (p *inPacket) release() {
+	if p.buf != nil {
+		packetBufPool.Put(p.buf)
+		p.buf = nil
+	}
+}
+
+// Stats 数据包处理的统计
+type Stats struct {
+	Received uint64 // 收到的数据包数
+	Dropped  uint64 // 队列满被丢弃的数据包数
+	Queued   int    // 队列中等待处理的数据包数
+}
+
+// workerPool 固定数量的协程处理收到的数据包
+type workerPool struct {
+	queue    chan *inPacket
+	workers  int
+	policy   OverflowPolicy
+	handle   func(p *inPacket)
+	received uint64
+	dropped  uint64
+}
+
+func newWorkerPool(workers, queueSize int, policy OverflowPolicy, handle func(p *inPacket)) *workerPool {
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+	return &workerPool{
+		queue:   make(chan *inPacket, queueSize),
+		workers: workers,
+		policy:  policy,
+		handle:  handle,
+	}
+}
+
+// start 启动处理协程，队列关闭且处理完剩余的包后退出
+func (p *workerPool) start(wg *sync.WaitGroup) {
+	for i := 0; i < p.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pkt := range p.queue {
+				p.handle(pkt)
+				pkt.release()
+			}
+		}()
+	}
+}
+
+// submit 放入处理队列，队列满且策略为丢弃时返回false并放回缓冲区
+func (p *workerPool) submit(pkt *inPacket) bool {
+	atomic.AddUint64(&p.received, 1)
+	if p.policy == OverflowBlock {
+		p.queue <- pkt
+		return true
+	}
+	select {
+	case p.queue <- pkt:
+		return true
+	default:
+		atomic.AddUint64(&p.dropped, 1)
+		pkt.release()
+		return false
+	}
+}
+
+// stop 关闭队列，只能由提交数据包的读取循环调用
+func (p *workerPool) stop() {
+	close(p.queue)
+}
+
+func (p *workerPool) stats() Stats {
+	return Stats{
+		Received: atomic.LoadUint64(&p.received),
+		Dropped:  atomic.LoadUint64(&p.dropped),
+		Queued:   len(p.queue),
+	}
+}
@@ -0,0 +1,111 @@
+package udp
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockedPool 1个处理协程、队列长度1的池，处理方法阻塞直到 release
+type blockedPool struct {
+	*workerPool
+	wg      sync.WaitGroup
+	started chan struct{}
+	gate    chan struct{}
+	handled chan int
+}
+
+func newBlockedPool(t *testing.T, policy OverflowPolicy) *blockedPool {
+	t.Helper()
+	b := &blockedPool{
+		started: make(chan struct{}, 8),
+		gate:    make(chan struct{}),
+		handled: make(chan int, 8),
+	}
+	b.workerPool = newWorkerPool(1, 1, policy, func(p *inPacket) {
+		b.started <- struct{}{}
+		<-b.gate
+		b.handled <- p.n
+	})
+	b.start(&b.wg)
+	return b
+}
+
+func (b *blockedPool) packet(n int) *inPacket {
+	return &inPacket{buf: packetBufPool.Get().(*[]byte), n: n}
+}
+
+// saturate 第1个包被处理协程取走并阻塞，第2个包占满队列
+func (b *blockedPool) saturate(t *testing.T) {
+	t.Helper()
+	if !b.submit(b.packet(1)) {
+		t.Fatal("第1个包被丢弃")
+	}
+	select {
+	case <-b.started:
+	case <-time.After(time.Second):
+		t.Fatal("处理协程没有取走第1个包")
+	}
+	if !b.submit(b.packet(2)) {
+		t.Fatal("第2个包被丢弃")
+	}
+}
+
+// finish 放开处理方法，关闭队列等待处理协程退出，返回处理过的包
+func (b *blockedPool) finish() []int {
+	close(b.gate)
+	b.stop()
+	b.wg.Wait()
+	close(b.handled)
+	var got []int
+	for n := range b.handled {
+		got = append(got, n)
+	}
+	return got
+}
+
+// TestPoolOverflowDrop 队列满时丢弃新的包并计入 Dropped
+func TestPoolOverflowDrop(t *testing.T) {
+	b := newBlockedPool(t, OverflowDrop)
+	b.saturate(t)
+	if b.submit(b.packet(3)) {
+		t.Fatal("队列满时第3个包应被丢弃")
+	}
+	if stats := b.stats(); stats.Received != 3 || stats.Dropped != 1 || stats.Queued != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if got := b.finish(); len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Fatalf("处理了 %v", got)
+	}
+}
+
+// TestPoolOverflowBlock 队列满时阻塞提交直到有空位，不丢包
+func TestPoolOverflowBlock(t *testing.T) {
+	b := newBlockedPool(t, OverflowBlock)
+	b.saturate(t)
+	submitted := make(chan bool, 1)
+	go func() {
+		submitted <- b.submit(b.packet(3))
+	}()
+	select {
+	case <-submitted:
+		t.Fatal("队列满时提交应阻塞")
+	case <-time.After(50 * time.Millisecond):
+	}
+	// 放开第1个包，队列腾出空位后第3个包入队
+	b.gate <- struct{}{}
+	select {
+	case ok := <-submitted:
+		if !ok {
+			t.Fatal("阻塞策略不应丢包")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("队列有空位后提交仍阻塞")
+	}
+	if stats := b.stats(); stats.Received != 3 || stats.Dropped != 0 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if got := b.finish(); len(got) != 3 || got[0] != 1 || got[1] != 2 || got[2] != 3 {
+		t.Fatalf("处理了 %v", got)
+	}
+}
@@ -7,24 +7,43 @@ import (
 	"crypto/des"
 	"encoding/binary"
 	"encoding/json"
+	"hash/crc32"
 	"io"
+	"math"
+	"strings"
+	"unicode/utf8"
 )
 
 /*
 
 Packet 包设计
+
+v1 包头
+__________________________________________________________________________________________________________________
+|            |          |          |          |              |             |             |          |            |
+| 魔数"UC"(2) | 版本(1) | 标志(1) | 指令(1) | 加密套件(1) | 编码(1) | 压缩(1) | name长度(1)+name(0~64字节) | 签名(7字节) | 长度(2) | CRC32(4) | data...
+|____________|__________|__________|__________|______________|_____________|_____________|__________|____________|
+
+v0 包头(旧版本，仍然接受)
 ______________________________________________________________________
 |            |              |             |                           |
 | 指令(1字节) |  name(7字节)  | 签名(7字节)  |  data(建议小于533字节)...  |
 |____________|______________|_____________|___________________________|
 
+魔数: v0 包的第一个字节是指令，'U' 对应的指令(0x15)不存在，据此区分两个版本
+版本: 包头的版本，不支持的版本直接丢弃
+标志: 数据是否压缩、是否加密、是否为分片包
 指令: 区分是什么数据
-name: 主要场景s端指定广播，name对应多个ip(节点)
+编码: data中信封结构(PutData, GetData, NoticeData, Reply)的编码(见 codec.go)，v0 总是JSON
+压缩: data使用的压缩算法(见 compress.go)，未压缩时为 CompressNone，v0 总是zlib
+name: 主要场景s端指定广播，name对应多个ip(节点)，v1 为变长的UTF-8字符串，v0 为7字节并用空格补齐
 签名: 用于确保数据安全，签名会更具心跳进行动态签发
-data: 传输的数据，不支持分包，建议小于533字节，可以在业务中设计分次传输
+长度: data的字节数，与收到的字节数不一致时丢弃
+CRC32: 除CRC32以外的包头与data的校验和，不是本协议的包或传输中损坏的包在解密前丢弃
+data: 传输的数据，加密后超过540字节的数据会被拆分为多个分片包(见 fragment.go)，接收端重组后再交给业务
 
-包安全: des加密保障数据包不是明文传输
-包压缩: 使用Zlib
+包安全: v1 使用独立的字节存放加密套件，v0 为指令字节的高2位, 支持 DES(旧版本)、AES-256-GCM(带完整性校验)、不加密
+包压缩: v1 可选 zlib(默认)、gzip、不压缩或自定义算法，小于 CompressMin 或压缩后没有变小的数据不压缩；v0 总是使用zlib
 
 场景:
 1. c -> s ; 必须建立连接
@@ -35,49 +54,167 @@ data: 传输的数据，不支持分包，建议小于533字节，可以在业
 */
 
 type Packet struct {
-	Command CommandCode
-	Name    string
-	Sign    string
-	Data    []byte
+	Command  CommandCode
+	Name     string
+	Sign     string
+	Data     []byte
+	Cipher   CipherSuite  // 对端使用的加密套件
+	Version  uint8        // 对端使用的包头版本
+	Codec    CodecType    // 对端使用的编码
+	Compress CompressType // 数据使用的压缩算法，未压缩时为 CompressNone
+}
+
+// 包头版本
+const (
+	PacketV0 uint8 = 0 // 旧版本的15字节包头，没有魔数与校验
+	PacketV1 uint8 = 1
+)
+
+const (
+	packetHeadLen   = 15 // v0 包头长度
+	packetV0NameLen = 7  // v0 包头中name的长度
+	packetV1HeadLen = 22 // v1 包头不含name的长度
+	packetV1NameAt  = 9  // v1 包头中name的位置，之前一个字节为name的长度
+)
+
+// packetV1Offset v1 包头中长度与CRC32的位置，长度之前的部分参与AES-GCM校验
+func packetV1Offset(nameLen int) (lenAt, crcAt int) {
+	lenAt = packetV1NameAt + nameLen + 7
+	return lenAt, lenAt + 2
+}
+
+var packetMagic = []byte("UC")
+
+// v1 包头的标志位
+const (
+	flagCompressed = 1 << 0 // data 已压缩
+	flagEncrypted  = 1 << 1 // data 已加密
+	flagFragment   = 1 << 2 // 分片包
+	flagMask       = flagCompressed | flagEncrypted | flagFragment
+)
+
+// packetConf 封包解包的配置
+type packetConf struct {
+	secret      string       // 秘钥
+	cipher      CipherSuite  // 封包使用的加密套件，解包时接受该套件，非严格模式时还接受DES
+	strict      bool         // 严格模式，解包时不接受旧版本的DES
+	version     uint8        // 封包使用的包头版本，解包时接受所有支持的版本
+	codec       CodecType    // 封包使用的编码，v0 包头不记录编码，总是JSON
+	compress    CompressType // 封包使用的压缩算法，解包时接受所有已注册的算法，v0 总是zlib
+	compressMin int          // 小于该字节数的数据不压缩
+}
+
+// accept 接收端是否接受对端使用的加密套件，配置的套件总是被接受
+// 旧版本的DES只在非严格模式并且秘钥为8个字节时被接受
+func (conf *packetConf) accept(suite CipherSuite) bool {
+	return suite == conf.cipher || (suite == CipherDES && !conf.strict && len(conf.secret) == desKeyLen)
+}
+
+func (conf *packetConf) headLen(name string) int {
+	if conf.version == PacketV0 {
+		return packetHeadLen
+	}
+	return packetV1HeadLen + len(name)
+}
+
+// packetHead 解析后的包头
+type packetHead struct {
+	version  uint8
+	flags    uint8
+	command  CommandCode
+	cipher   CipherSuite
+	codec    CodecType
+	compress CompressType
+	name     string
+	sign     string
+	size     int    // 包头长度
+	aad      []byte // AES-GCM 参与校验的包头
 }
 
-// PacketEncoder 封包
+// PacketEncoder 封包, 使用DES加密与v0包头
 func PacketEncoder(cmd CommandCode, name, sign, secret string, data []byte) ([]byte, error) {
+	stream, err := packetEncoder(cmd, name, sign, data, &packetConf{secret: secret, cipher: CipherDES})
+	if err != nil {
+		return stream, err
+	}
+	if n := len(stream) - packetHeadLen; n > PacketDataMax {
+		Error(ErrPacketDataAbove(n, PacketDataMax))
+	}
+	return stream, nil
+}
+
+func packetEncoder(cmd CommandCode, name, sign string, data []byte, conf *packetConf) ([]byte, error) {
+	// 压缩数据
+	dCompress, compress, err := packetCompress(conf, data)
+	if err != nil {
+		return nil, err
+	}
+	return packetEncoderCompressed(cmd, name, sign, dCompress, compress, conf)
+}
+
+// packetEncoderCompressed 封包，dCompress 为已使用 compress 压缩的数据
+func packetEncoderCompressed(cmd CommandCode, name, sign string, dCompress []byte, compress CompressType, conf *packetConf) ([]byte, error) {
 	var (
 		err    error
 		stream []byte
 		buf    = new(bytes.Buffer)
 	)
-	_ = binary.Write(buf, binary.LittleEndian, cmd)
-	ln := len(name)
-	if ln > 0 && ln <= 7 {
-		// 补齐位
-		for i := 0; i < 7-ln; i++ {
-			name += " "
+	if conf.version == PacketV0 {
+		// 指令的高2位存放加密套件
+		_ = binary.Write(buf, binary.LittleEndian, uint8(cmd)|uint8(conf.cipher)<<cipherShift)
+	} else {
+		flags := uint8(0)
+		if compress != CompressNone {
+			flags |= flagCompressed
+		}
+		if conf.cipher != CipherNone {
+			flags |= flagEncrypted
+		}
+		if cmd == CommandFragment {
+			flags |= flagFragment
+		}
+		_, _ = buf.Write(packetMagic)
+		_ = binary.Write(buf, binary.LittleEndian, []uint8{conf.version, flags, uint8(cmd), uint8(conf.cipher), uint8(conf.codec), uint8(compress)})
+	}
+	if conf.version == PacketV0 {
+		// v0 的name为7字节，超出时截断
+		name = truncateName(name, packetV0NameLen)
+		ln := len(name)
+		if ln > 0 {
+			// 补齐位
+			for i := 0; i < packetV0NameLen-ln; i++ {
+				name += " "
+			}
+			_ = binary.Write(buf, binary.LittleEndian, []byte(name))
+		} else {
+			_ = binary.Write(buf, binary.LittleEndian, []byte("0000000"))
 		}
-		_ = binary.Write(buf, binary.LittleEndian, []byte(name))
-	} else if ln > 7 {
-		return nil, ErrNmeLengthAbove
 	} else {
-		_ = binary.Write(buf, binary.LittleEndian, []byte("0000000"))
+		if len(name) > NameMaxLen {
+			return nil, ErrNmeLengthAbove
+		}
+		_ = buf.WriteByte(uint8(len(name)))
+		_, _ = buf.WriteString(name)
 	}
 	if len(sign) != 7 {
 		_ = binary.Write(buf, binary.LittleEndian, []byte("0000000"))
 	} else {
 		_ = binary.Write(buf, binary.LittleEndian, []byte(sign))
 	}
-	//Info("源数据 : ", len(data))
-	// 压缩数据
-	//d := GzipCompress(data)
-	dCompress := ZlibCompress(data)
-	//Info("压缩后数据长度: ", len(d))
-
 	// 加密数据
-	dEncrypt := DesECBEncrypt(dCompress, []byte(secret))
+	dEncrypt, err := packetEncrypt(conf, buf.Bytes(), dCompress)
+	if err != nil {
+		return stream, err
+	}
 	//Info("加密数据 : ", len(d))
 
-	if len(dEncrypt) > 540 {
-		Error(ErrDataLengthAbove)
+	if conf.version != PacketV0 {
+		if len(dEncrypt) > math.MaxUint16 {
+			return stream, ErrPacketDataAbove(len(dEncrypt), math.MaxUint16)
+		}
+		_ = binary.Write(buf, binary.BigEndian, uint16(len(dEncrypt)))
+		crc := crc32.Update(crc32.ChecksumIEEE(buf.Bytes()), crc32.IEEETable, dEncrypt)
+		_ = binary.Write(buf, binary.BigEndian, crc)
 	}
 	err = binary.Write(buf, binary.LittleEndian, dEncrypt)
 	if err != nil {
@@ -87,34 +224,149 @@ func PacketEncoder(cmd CommandCode, name, sign, secret string, data []byte) ([]b
 	return stream, nil
 }
 
-// PacketDecrypt 解包
+// PacketDecrypt 解包, 接受DES加密的包，包头可以是v0或v1
 func PacketDecrypt(secret string, data []byte, n int) (*Packet, error) {
-	var err error
-	if n < 15 {
+	return packetDecrypt(data, n, &packetConf{secret: secret, cipher: CipherDES})
+}
+
+// packetHeader 解析并校验包头，包头不加密，解密失败时也可以读取
+func packetHeader(data []byte, n int) (*packetHead, error) {
+	if n < packetHeadLen {
 		Error("空包")
 		return nil, ErrNonePacket
 	}
-	command := CommandCode(data[0:1][0])
-	name := string(data[1:8])
-	sign := string(data[8:15])
-	b := data[15:n]
+	if !bytes.HasPrefix(data[:n], packetMagic) {
+		return &packetHead{
+			version:  PacketV0,
+			flags:    flagCompressed,
+			command:  CommandCode(data[0] & commandMask),
+			cipher:   CipherSuite(data[0] >> cipherShift),
+			codec:    CodecJSON,
+			compress: CompressZlib,
+			name:     strings.TrimRight(string(data[1:8]), " "),
+			sign:     string(data[8:15]),
+			size:     packetHeadLen,
+			aad:      data[:packetHeadLen],
+		}, nil
+	}
+	if n < packetV1HeadLen {
+		return nil, ErrPacketHead
+	}
+	if data[2] != PacketV1 {
+		return nil, ErrPacketVersion(data[2])
+	}
+	nameLen := int(data[packetV1NameAt-1])
+	size := packetV1HeadLen + nameLen
+	if nameLen > NameMaxLen || n < size {
+		return nil, ErrPacketHead
+	}
+	lenAt, crcAt := packetV1Offset(nameLen)
+	if int(binary.BigEndian.Uint16(data[lenAt:])) != n-size {
+		return nil, ErrPacketHead
+	}
+	crc := crc32.Update(crc32.ChecksumIEEE(data[:crcAt]), crc32.IEEETable, data[size:n])
+	if crc != binary.BigEndian.Uint32(data[crcAt:]) {
+		return nil, ErrPacketChecksum
+	}
+	head := &packetHead{
+		version:  data[2],
+		flags:    data[3],
+		command:  CommandCode(data[4]),
+		cipher:   CipherSuite(data[5]),
+		codec:    CodecType(data[6]),
+		compress: CompressType(data[7]),
+		name:     string(data[packetV1NameAt : packetV1NameAt+nameLen]),
+		sign:     string(data[lenAt-7 : lenAt]),
+		size:     size,
+		aad:      data[:lenAt],
+	}
+	// 标志与加密套件、指令不一致，name不是有效的UTF-8
+	if !utf8.ValidString(head.name) ||
+		head.flags&^flagMask != 0 ||
+		(head.flags&flagEncrypted != 0) != (head.cipher != CipherNone) ||
+		(head.flags&flagCompressed != 0) != (head.compress != CompressNone) ||
+		(head.flags&flagFragment != 0) != (head.command == CommandFragment) {
+		return nil, ErrPacketHead
+	}
+	return head, nil
+}
+
+func packetDecrypt(data []byte, n int, conf *packetConf) (*Packet, error) {
+	head, err := packetHeader(data, n)
+	if err != nil {
+		return nil, err
+	}
+	return packetDecode(head, data[:n], conf)
+}
+
+// packetDecode 按已校验的包头解密解压数据，data 为完整的包
+func packetDecode(head *packetHead, data []byte, conf *packetConf) (*Packet, error) {
+	if !conf.accept(head.cipher) {
+		return nil, ErrCipherSuite(head.cipher)
+	}
+	if _, err := getCodec(head.codec); err != nil {
+		return nil, err
+	}
+	compressor, err := getCompressor(head.compress)
+	if err != nil {
+		return nil, err
+	}
+	b := data[head.size:]
 	// 解密数据
-	bDecrypt := DesECBDecrypt(b, []byte(secret))
-	// 解压数据
-	//b, err := GzipDecompress(data[15:n])
-	bDecompress, err := ZlibDecompress(bDecrypt)
+	bDecrypt, err := packetDecryptData(head.cipher, conf.secret, head.aad, b)
 	if err != nil {
-		Error("解压数据失败 err: ", err)
 		return nil, err
 	}
+	// v1 分片包的压缩算法指整个消息，分片到齐后再解压，v0 的每个包总是zlib压缩
+	if head.flags&flagCompressed != 0 && (head.command != CommandFragment || head.version == PacketV0) {
+		// 解压数据
+		bDecrypt, err = decompress(compressor, bDecrypt, DataMax)
+		if err != nil {
+			Error("解压数据失败 err: ", err)
+			return nil, err
+		}
+	} else if head.cipher == CipherNone {
+		// 不加密也没有解压时数据引用的是读缓冲，缓冲在处理前已放回池中，需要复制
+		bDecrypt = append([]byte(nil), bDecrypt...)
+	}
 	return &Packet{
-		Command: command,
-		Name:    name,
-		Sign:    sign,
-		Data:    bDecompress,
+		Command:  head.command,
+		Name:     head.name,
+		Sign:     head.sign,
+		Data:     bDecrypt,
+		Cipher:   head.cipher,
+		Version:  head.version,
+		Codec:    head.codec,
+		Compress: head.compress,
 	}, nil
 }
 
+// packetEncrypt 按加密套件加密数据，AES-GCM 会将包头作为附加数据参与校验
+func packetEncrypt(conf *packetConf, head, data []byte) ([]byte, error) {
+	switch conf.cipher {
+	case CipherDES:
+		return DesECBEncrypt(data, []byte(conf.secret)), nil
+	case CipherAESGCM:
+		return AesGCMEncrypt(data, []byte(conf.secret), head)
+	case CipherNone:
+		return data, nil
+	}
+	return nil, ErrCipherSuite(conf.cipher)
+}
+
+func packetDecryptData(suite CipherSuite, secret string, head, data []byte) ([]byte, error) {
+	switch suite {
+	case CipherDES:
+		return DesECBDecrypt(data, []byte(secret)), nil
+	case CipherAESGCM:
+		return AesGCMDecrypt(data, []byte(secret), head)
+	case CipherNone:
+		return data, nil
+	}
+	return nil, ErrCipherSuite(suite)
+}
+
+// ObjToByte JSON编码，用于积压数据的持久化，传输时的信封结构使用 encodeObj
 func ObjToByte(obj interface{}) ([]byte, error) {
 	b, err := json.Marshal(obj)
 	if err != nil {
@@ -0,0 +1,207 @@
+package udp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"strings"
+	"testing"
+
+	"github.com/mangenotwork/udp_comm/simnet"
+)
+
+// resealCRC 修改包头后重新计算校验和
+func resealCRC(b []byte) []byte {
+	nameLen := int(b[packetV1NameAt-1])
+	_, crcAt := packetV1Offset(nameLen)
+	crc := crc32.Update(crc32.ChecksumIEEE(b[:crcAt]), crc32.IEEETable, b[packetV1HeadLen+nameLen:])
+	binary.BigEndian.PutUint32(b[crcAt:], crc)
+	return b
+}
+
+func TestPacketHeaderVersion(t *testing.T) {
+	data := bytes.Repeat([]byte("data"), 200)
+	for _, version := range []uint8{PacketV0, PacketV1} {
+		for _, cipher := range []CipherSuite{CipherDES, CipherAESGCM, CipherNone} {
+			conf := &packetConf{secret: DefaultSecretKey, cipher: cipher, version: version}
+			stream, err := packetEncoder(CommandPut, "name", "abcdefg", data, conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			packet, err := packetDecrypt(stream, len(stream), conf)
+			if err != nil {
+				t.Fatalf("v%d %s: %v", version, cipher, err)
+			}
+			if packet.Version != version || packet.Cipher != cipher || packet.Command != CommandPut ||
+				packet.Sign != "abcdefg" || !bytes.Equal(packet.Data, data) {
+				t.Fatalf("v%d %s: 解包结果不一致 %+v", version, cipher, packet)
+			}
+		}
+	}
+}
+
+func TestPacketHeaderReject(t *testing.T) {
+	conf := &packetConf{secret: DefaultSecretKey, cipher: CipherDES, version: PacketV1}
+	stream, err := packetEncoder(CommandPut, "name", "abcdefg", []byte("data"), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := func(fn func(b []byte) []byte) []byte {
+		return fn(append([]byte{}, stream...))
+	}
+	cases := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"data", corrupt(func(b []byte) []byte { b[len(b)-1] ^= 1; return b }), ErrPacketChecksum},
+		{"sign", corrupt(func(b []byte) []byte { b[15] ^= 1; return b }), ErrPacketChecksum},
+		{"length", corrupt(func(b []byte) []byte { return b[:len(b)-1] }), ErrPacketHead},
+		{"flags", corrupt(func(b []byte) []byte { b[3] |= flagFragment; return resealCRC(b) }), ErrPacketHead},
+		{"cipher", corrupt(func(b []byte) []byte { b[5] = uint8(CipherNone); return resealCRC(b) }), ErrPacketHead},
+		{"short", stream[:packetV1HeadLen-1], ErrPacketHead},
+	}
+	for _, v := range cases {
+		if _, err := packetHeader(v.data, len(v.data)); err != v.err {
+			t.Fatalf("%s: err = %v, 应为 %v", v.name, err, v.err)
+		}
+	}
+	future := corrupt(func(b []byte) []byte { b[2] = 2; return b })
+	if _, err := packetHeader(future, len(future)); err == nil || err.Error() != ErrPacketVersion(2).Error() {
+		t.Fatalf("未知版本 err = %v", err)
+	}
+}
+
+func TestPacketName(t *testing.T) {
+	long := strings.Repeat("节点", 10) + "-collector" // 超过64字节的UTF-8名称，截断为64字节
+	long = truncateName(long, NameMaxLen)
+	cases := []struct {
+		version uint8
+		name    string
+		want    string
+	}{
+		{PacketV1, long, long},
+		{PacketV1, "a", "a"},
+		{PacketV0, "a", "a"},               // 去掉补齐位
+		{PacketV0, "servers", "servers"},   // 正好7个字节
+		{PacketV0, "collector", "collect"}, // 截断为7个字节
+		{PacketV0, "节点名", "节点"},            // 不截断多字节字符
+	}
+	for _, v := range cases {
+		conf := &packetConf{secret: DefaultSecretKey, cipher: CipherDES, version: v.version}
+		stream, err := packetEncoder(CommandPut, v.name, "abcdefg", []byte("data"), conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		packet, err := packetDecrypt(stream, len(stream), conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if packet.Name != v.want || packet.Sign != "abcdefg" {
+			t.Fatalf("v%d name %q: 解包为 %q", v.version, v.name, packet.Name)
+		}
+	}
+	conf := &packetConf{secret: DefaultSecretKey, version: PacketV1}
+	if _, err := packetEncoder(CommandPut, strings.Repeat("a", NameMaxLen+1), "", nil, conf); err != ErrNmeLengthAbove {
+		t.Fatalf("超长名称 err = %v", err)
+	}
+	if err := checkName(string([]byte{0xff, 'a'})); err != ErrNameInvalid {
+		t.Fatalf("无效的UTF-8 err = %v", err)
+	}
+}
+
+func TestCipherStrict(t *testing.T) {
+	stream, err := packetEncoder(CommandPut, "name", "abcdefg", []byte("data"),
+		&packetConf{secret: DefaultSecretKey, cipher: CipherDES, version: PacketV1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key32 := strings.Repeat("k", aesKeyLen)
+	cases := []struct {
+		name string
+		conf *packetConf
+		ok   bool
+	}{
+		{"compat", &packetConf{secret: DefaultSecretKey, cipher: CipherAESGCM}, true},
+		{"strict", &packetConf{secret: DefaultSecretKey, cipher: CipherAESGCM, strict: true}, false},
+		{"strict des", &packetConf{secret: DefaultSecretKey, cipher: CipherDES, strict: true}, true},
+		{"key32", &packetConf{secret: key32, cipher: CipherAESGCM}, false},
+	}
+	for _, v := range cases {
+		_, err := packetDecrypt(stream, len(stream), v.conf)
+		if v.ok && err != nil {
+			t.Fatalf("%s: %v", v.name, err)
+		}
+		if !v.ok && (err == nil || err.Error() != ErrCipherSuite(CipherDES).Error()) {
+			t.Fatalf("%s: err = %v", v.name, err)
+		}
+	}
+}
+
+// TestHkdfSha256 RFC 5869 测试用例1，输出的前32个字节
+func TestHkdfSha256(t *testing.T) {
+	ikm := bytes.Repeat([]byte{0x0b}, 22)
+	salt, _ := hex.DecodeString("000102030405060708090a0b0c")
+	info, _ := hex.DecodeString("f0f1f2f3f4f5f6f7f8f9")
+	want := "3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf"
+	if got := hex.EncodeToString(hkdfSha256(ikm, salt, info)); got != want {
+		t.Fatalf("okm = %s", got)
+	}
+}
+
+func TestAesKey32(t *testing.T) {
+	key32 := strings.Repeat("k", aesKeyLen)
+	data := bytes.Repeat([]byte("data"), 100)
+	conf := &packetConf{secret: key32, cipher: CipherAESGCM, version: PacketV1}
+	stream, err := packetEncoder(CommandPut, "name", "abcdefg", data, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet, err := packetDecrypt(stream, len(stream), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(packet.Data, data) {
+		t.Fatal("解包结果不一致")
+	}
+	// 32个字节的秘钥直接使用，不与派生的秘钥相同
+	if _, err := packetDecrypt(stream, len(stream), &packetConf{secret: key32[:8], cipher: CipherAESGCM}); err != ErrPacketAuth {
+		t.Fatalf("err = %v", err)
+	}
+
+	if _, err := newServers(ServersConf{SecretKey: key32}); err != ErrServersSecretKey {
+		t.Fatalf("DES不能使用32个字节的秘钥 err = %v", err)
+	}
+	if _, err := newClient("", ClientConf{SecretKey: strings.Repeat("k", 16), Cipher: CipherAESGCM}); err != ErrClientSecretKey {
+		t.Fatalf("err = %v", err)
+	}
+	s, err := newServers(ServersConf{SecretKey: key32, Cipher: CipherAESGCM})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetCipher(CipherDES); err != ErrServersSecretKey || s.cipher != CipherAESGCM {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+// TestCipherStrictPair 两端都使用32个字节的秘钥与严格模式
+func TestCipherStrictPair(t *testing.T) {
+	network := simnet.New(simnet.Conf{}, 1)
+	key32 := strings.Repeat("k", aesKeyLen)
+	_, c := simPairConf(t, network, func(sConf *ServersConf, cConf *ClientConf) {
+		sConf.SecretKey, sConf.Cipher, sConf.StrictCipher = key32, CipherAESGCM, true
+		cConf.SecretKey, cConf.Cipher, cConf.StrictCipher = key32, CipherAESGCM, true
+	}, func(s *Servers, c *Client) {
+		s.GetHandleFunc("get", func(s *Servers, param []byte) (int, []byte) {
+			return 0, append([]byte("re:"), param...)
+		})
+	})
+	rse, err := c.Get("get", []byte("param"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rse) != "re:param" {
+		t.Fatalf("rse = %s", rse)
+	}
+}
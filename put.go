@@ -1,6 +1,10 @@
 package udp
 
-import "net"
+import (
+	"context"
+	"net"
+	"time"
+)
 
 type PutData struct {
 	Label string // 标签，用于区分当前数据处理的方法
@@ -10,11 +14,22 @@ type PutData struct {
 
 type ServersPutFunc map[string]func(s *Servers, c *ClientInfo, data []byte)
 
+type ServersPutContextFunc map[string]func(ctx context.Context, s *Servers, c *ClientInfo, data []byte) (int, []byte)
+
 type ClientInfo struct {
 	Name        string
-	Addr        *net.UDPAddr
+	Addr        net.Addr
 	Interactive int64
 	PacketSize  int
 }
 
+func newClientInfo(name string, addr net.Addr, packetSize int) *ClientInfo {
+	return &ClientInfo{
+		Name:        name,
+		Addr:        addr,
+		Interactive: time.Now().Unix(),
+		PacketSize:  packetSize,
+	}
+}
+
 // TODO 给ClientInfo 下发消息，场景是 S端收到C端发来的PUT, S端可以直接进行应答
@@ -0,0 +1,90 @@
+package udp
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mangenotwork/udp_comm/simnet"
+)
+
+// putWaitLen 等待确认中的put数量
+func putWaitLen(c *Client) int {
+	n := 0
+	c.putWait.Range(func(key, value any) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// TestPutWaitSign 签名失败时返回 ErrPutSign，数据留在积压中，重新连接后送达
+func TestPutWaitSign(t *testing.T) {
+	network := simnet.New(simnet.Conf{}, 1)
+	got := newReceived()
+	// 心跳应答会重新下发签名，测试期间不发送心跳
+	_, c := simPairConf(t, network, func(sConf *ServersConf, cConf *ClientConf) {
+		cConf.Heartbeat = time.Hour
+	}, func(s *Servers, c *Client) {
+		s.PutHandleFunc("case", func(s *Servers, c *ClientInfo, body []byte) {
+			got.add(body)
+		})
+	})
+	waitFor(t, 2*time.Second, "client没有连接", func() bool {
+		return c.State() == StateConnected
+	})
+	// 等待连接时重试发出的连接包的应答处理完，之后签名不再变化
+	time.Sleep(50 * time.Millisecond)
+	// 当前与上一个签名都失效，c端收到签名错误的应答后重新连接
+	c.setSign("bad-sign-1")
+	c.setSign("bad-sign-2")
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := c.PutWait(ctx, "case", []byte("sign")); !errors.Is(err, ErrPutSign) {
+		t.Fatalf("err = %v", err)
+	}
+	if n := putWaitLen(c); n != 0 {
+		t.Fatalf("putWait 残留 %d", n)
+	}
+	waitFor(t, 2*time.Second, "重新连接后积压数据没有送达", func() bool {
+		return got.has("sign") && c.BacklogLen() == 0
+	})
+}
+
+// TestPutWaitContext 收不到确认时按ctx返回超时或取消，等待被清理，数据留在积压中
+func TestPutWaitContext(t *testing.T) {
+	network := simnet.New(simnet.Conf{}, 1)
+	got := newReceived()
+	_, c := simPair(t, network, func(s *Servers, c *Client) {
+		s.PutHandleFunc("case", func(s *Servers, c *ClientInfo, body []byte) {
+			got.add(body)
+		})
+	})
+	network.Partition(simnet.Addr("servers"), simnet.Addr("client"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := c.PutWait(ctx, "case", []byte("timeout"))
+	if !errors.Is(err, ErrPutTimeOut) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	err = c.PutWait(ctx, "case", []byte("cancel"))
+	if !errors.Is(err, ErrPutCanceled) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v", err)
+	}
+	if n := putWaitLen(c); n != 0 {
+		t.Fatalf("putWait 残留 %d", n)
+	}
+	if n := c.BacklogLen(); n != 2 {
+		t.Fatalf("积压 %d 条, 应为 2", n)
+	}
+
+	network.HealAll()
+	waitFor(t, 5*time.Second, "恢复后积压数据没有送达", func() bool {
+		return got.has("timeout") && got.has("cancel") && c.BacklogLen() == 0
+	})
+}
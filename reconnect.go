@@ -0,0 +1,105 @@
+package udp
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// ReconnectPolicy 判定servers丢失后的重连策略，按指数退避重发连接包
+// 每次的等待时间在 [delay*(1-Jitter), delay*(1+Jitter)] 内随机，避免servers重启后所有client同时重连
+type ReconnectPolicy struct {
+	InitialDelay time.Duration // 第一次重连前的等待时间 默认1s
+	MaxDelay     time.Duration // 等待时间的上限 默认30s
+	Multiplier   float64       // 每次重连失败后等待时间的倍数 默认2
+	Jitter       float64       // 随机抖动的比例 0~1 默认0.2
+	MaxAttempts  int           // 最大重连次数，0为不限制，超过后保持 Disconnected 由心跳继续尝试
+}
+
+// DefaultReconnectPolicy 默认的重连策略
+func DefaultReconnectPolicy() ReconnectPolicy {
+	return ReconnectPolicy{
+		InitialDelay: DefaultReconnectInitialDelay * time.Second,
+		MaxDelay:     DefaultReconnectMaxDelay * time.Second,
+		Multiplier:   DefaultReconnectMultiplier,
+		Jitter:       DefaultReconnectJitter,
+	}
+}
+
+// normalize 未设置的字段使用默认值
+func (p ReconnectPolicy) normalize() ReconnectPolicy {
+	def := DefaultReconnectPolicy()
+	if p.InitialDelay <= 0 {
+		p.InitialDelay = def.InitialDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = def.MaxDelay
+	}
+	if p.MaxDelay < p.InitialDelay {
+		p.MaxDelay = p.InitialDelay
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = def.Multiplier
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		p.Jitter = def.Jitter
+	}
+	return p
+}
+
+// next 下一次的等待时间
+func (p ReconnectPolicy) next(delay time.Duration) time.Duration {
+	delay = time.Duration(float64(delay) * p.Multiplier)
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// jitter 对等待时间加入随机抖动
+func (p ReconnectPolicy) jitter(r *rand.Rand, delay time.Duration) time.Duration {
+	if p.Jitter == 0 {
+		return delay
+	}
+	return time.Duration(float64(delay) * (1 + p.Jitter*(2*r.Float64()-1)))
+}
+
+// reconnect 启动重连，同一时间只有一个重连协程，连接成功、重连次数用尽或Client关闭时退出
+func (c *Client) reconnect() {
+	if !atomic.CompareAndSwapInt32(&c.reconnecting, 0, 1) {
+		return
+	}
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		defer atomic.StoreInt32(&c.reconnecting, 0)
+		r := rand.New(rand.NewSource(time.Now().UnixNano()))
+		delay := c.reconnectPolicy.InitialDelay
+		for attempt := 1; ; attempt++ {
+			timer := time.NewTimer(c.reconnectPolicy.jitter(r, delay))
+			select {
+			case <-c.done:
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			if c.online() {
+				return
+			}
+			if c.reconnectPolicy.MaxAttempts > 0 && attempt > c.reconnectPolicy.MaxAttempts {
+				ErrorF("重连servers失败，已重试%d次", c.reconnectPolicy.MaxAttempts)
+				c.setState(StateDisconnected)
+				return
+			}
+			// 多servers时依次切换到下一个servers
+			c.failover()
+			i, _ := c.currentServer()
+			c.resolveServer(i)
+			_, addr := c.currentServer()
+			InfoF("第%d次重连servers %s", attempt, addr)
+			c.setState(StateConnecting)
+			c.ConnectServers()
+			delay = c.reconnectPolicy.next(delay)
+		}
+	}()
+}
@@ -0,0 +1,99 @@
+package udp
+
+import (
+	"context"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mangenotwork/udp_comm/simnet"
+)
+
+// TestReconnectBackoff 等待时间按倍数增长直到上限
+func TestReconnectBackoff(t *testing.T) {
+	p := ReconnectPolicy{InitialDelay: 100 * time.Millisecond, MaxDelay: time.Second, Multiplier: 2}.normalize()
+	want := []time.Duration{200, 400, 800, 1000, 1000}
+	delay := p.InitialDelay
+	for i, w := range want {
+		delay = p.next(delay)
+		if delay != w*time.Millisecond {
+			t.Fatalf("第%d次 delay = %s, 应为 %dms", i+1, delay, w)
+		}
+	}
+}
+
+// TestReconnectJitter 抖动后的等待时间在 [delay*(1-Jitter), delay*(1+Jitter)] 内，且确实有随机
+func TestReconnectJitter(t *testing.T) {
+	p := ReconnectPolicy{Jitter: 0.2}.normalize()
+	r := rand.New(rand.NewSource(1))
+	delay := time.Second
+	lo, hi := 800*time.Millisecond, 1200*time.Millisecond
+	seen := make(map[time.Duration]struct{})
+	for i := 0; i < 1000; i++ {
+		d := p.jitter(r, delay)
+		if d < lo || d > hi {
+			t.Fatalf("jitter = %s, 超出 [%s, %s]", d, lo, hi)
+		}
+		seen[d] = struct{}{}
+	}
+	if len(seen) < 100 {
+		t.Fatalf("抖动没有生效 只有 %d 个不同的值", len(seen))
+	}
+	p.Jitter = 0
+	if d := p.jitter(r, delay); d != delay {
+		t.Fatalf("Jitter 为0时 delay = %s", d)
+	}
+}
+
+// TestReconnectMaxAttempts 重连次数用尽后停止发送连接包，保持 Disconnected
+func TestReconnectMaxAttempts(t *testing.T) {
+	network := simnet.New(simnet.Conf{}, 1)
+	conn, err := network.Listen("servers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	var connects int32
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if head, err := packetHeader(buf[:n], n); err == nil && head.command == CommandConnect {
+				atomic.AddInt32(&connects, 1)
+			}
+		}
+	}()
+	c, _ := newSimClient(t, network, "client", func(cConf *ClientConf) {
+		cConf.Reconnect = ReconnectPolicy{
+			InitialDelay: 5 * time.Millisecond,
+			MaxDelay:     10 * time.Millisecond,
+			MaxAttempts:  3,
+		}
+	})
+	defer func() {
+		_ = c.Shutdown(context.Background())
+	}()
+	// 创建时发送的第一个连接包
+	waitFor(t, time.Second, "没有收到创建时的连接包", func() bool {
+		return atomic.LoadInt32(&connects) == 1
+	})
+	c.setState(StateDisconnected)
+	c.reconnect()
+	waitFor(t, 2*time.Second, "重连次数用尽后没有退出", func() bool {
+		return atomic.LoadInt32(&c.reconnecting) == 0
+	})
+	// 等待最后一个连接包送达
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadInt32(&connects) - 1; n != 3 {
+		t.Fatalf("重连发送了 %d 次连接包, 应为 3", n)
+	}
+	if c.State() != StateDisconnected {
+		t.Fatalf("state = %s", c.State())
+	}
+}
@@ -0,0 +1,238 @@
+package udp
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// ClientRegistry servers端的客户端注册表，并发安全
+// 读取类的方法返回的都是快照，修改快照不会影响注册表
+type ClientRegistry struct {
+	mu          sync.RWMutex
+	conns       map[string]map[string]*ClientConnectObj // name -> ip+port -> obj
+	addrs       map[string]string                       // ip+port -> name
+	onLineTable map[string]*ClientConnInfo              // c端的在线表 key= name@ip
+}
+
+type ClientConnectObj struct {
+	Name string
+	IP   string
+	Addr net.Addr
+	Last int64 // 最后一次连接的时间
+}
+
+func newClientRegistry() *ClientRegistry {
+	return &ClientRegistry{
+		conns:       make(map[string]map[string]*ClientConnectObj),
+		addrs:       make(map[string]string),
+		onLineTable: make(map[string]*ClientConnInfo),
+	}
+}
+
+func onLineKey(name, ip string) string {
+	return fmt.Sprintf("%s@%s", name, ip)
+}
+
+// join 的结果
+const (
+	joinRefresh   = iota // 在线的连接刷新了最后连接时间
+	joinNew              // 首次连接
+	joinReconnect        // 离线后重新连接，或同一 name+ip 的新端口(如NAT重新映射)
+)
+
+// join 存储c端的连接，同一地址更换了name时移除旧的记录
+// 是否为新连接按该地址自己的记录判断，同一主机上同名的多个c端各自刷新，不会互相触发重连
+func (r *ClientRegistry) join(name, ip string, addr net.Addr) int {
+	t := time.Now().Unix()
+	key := addr.String()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	state := joinRefresh
+	if _, ok := r.conns[name][key]; !ok {
+		if _, ok := r.onLineTable[onLineKey(name, ip)]; ok {
+			state = joinReconnect
+		} else {
+			state = joinNew
+		}
+	}
+	if old, ok := r.addrs[key]; ok && old != name {
+		r.remove(old, key)
+	}
+	if _, ok := r.conns[name]; !ok {
+		r.conns[name] = make(map[string]*ClientConnectObj)
+	}
+	r.conns[name][key] = &ClientConnectObj{
+		Name: name,
+		IP:   ip,
+		Addr: addr,
+		Last: t,
+	}
+	r.addrs[key] = name
+	r.onLineTable[onLineKey(name, ip)] = &ClientConnInfo{
+		Name:        name,
+		Online:      true,
+		IP:          ip,
+		Addr:        key,
+		LastTime:    t,
+		DiscardTime: 0,
+	}
+	return state
+}
+
+func (r *ClientRegistry) remove(name, key string) {
+	if v, ok := r.conns[name]; ok {
+		delete(v, key)
+		if len(v) == 0 {
+			delete(r.conns, name)
+		}
+	}
+	delete(r.addrs, key)
+}
+
+// discard 移除name下指定ip的连接并标记为离线，返回被移除的连接
+func (r *ClientRegistry) discard(name, ip string) []ClientConnectObj {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	list := make([]ClientConnectObj, 0)
+	for k, c := range r.conns[name] {
+		if c.IP != ip {
+			continue
+		}
+		list = append(list, *c)
+		r.remove(name, k)
+	}
+	if clientConnInfo := r.onLineTable[onLineKey(name, ip)]; clientConnInfo != nil && clientConnInfo.Online {
+		clientConnInfo.Online = false
+		clientConnInfo.DiscardTime = time.Now().Unix()
+	}
+	return list
+}
+
+// expire 按地址移除最后一次连接时间早于 last 的连接，返回被移除的连接
+// 同一 name+ip 的其他端口仍在线时不影响它们，所有端口都离线后才在在线表中标记离线
+func (r *ClientRegistry) expire(last int64) []ClientConnectObj {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	list := make([]ClientConnectObj, 0)
+	for name, v := range r.conns {
+		for k, c := range v {
+			if c.Last < last {
+				list = append(list, *c)
+				r.remove(name, k)
+			}
+		}
+	}
+	t := time.Now().Unix()
+	for _, c := range list {
+		if r.online(c.Name, c.IP) {
+			continue
+		}
+		if clientConnInfo := r.onLineTable[onLineKey(c.Name, c.IP)]; clientConnInfo != nil && clientConnInfo.Online {
+			clientConnInfo.Online = false
+			clientConnInfo.DiscardTime = t
+		}
+	}
+	return list
+}
+
+// online name下是否还有该ip的连接
+func (r *ClientRegistry) online(name, ip string) bool {
+	for _, c := range r.conns[name] {
+		if c.IP == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// Names 所有在线的客户端名称
+func (r *ClientRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	nameList := make([]string, 0, len(r.conns))
+	for name := range r.conns {
+		nameList = append(nameList, name)
+	}
+	return nameList
+}
+
+// GetFromName name下的所有连接 key= ip+port
+func (r *ClientRegistry) GetFromName(name string) (map[string]*ClientConnectObj, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	v, ok := r.conns[name]
+	if !ok || len(v) < 1 {
+		return nil, false
+	}
+	list := make(map[string]*ClientConnectObj, len(v))
+	for k, c := range v {
+		obj := *c
+		list[k] = &obj
+	}
+	return list, true
+}
+
+// GetFromIP name下指定ip的一个连接，ip为空时取name下的任意一个连接
+func (r *ClientRegistry) GetFromIP(name, ip string) (*ClientConnectObj, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, c := range r.conns[name] {
+		if ip == "" || c.IP == ip {
+			obj := *c
+			return &obj, true
+		}
+	}
+	return nil, false
+}
+
+// GetFromAddr 根据连接地址 ip+port 获取连接
+func (r *ClientRegistry) GetFromAddr(addr string) (*ClientConnectObj, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	name, ok := r.addrs[addr]
+	if !ok {
+		return nil, false
+	}
+	c, ok := r.conns[name][addr]
+	if !ok {
+		return nil, false
+	}
+	obj := *c
+	return &obj, true
+}
+
+// Range 遍历所有连接的快照，fn返回false停止遍历
+func (r *ClientRegistry) Range(fn func(c ClientConnectObj) bool) {
+	for _, c := range r.Snapshot() {
+		if !fn(c) {
+			return
+		}
+	}
+}
+
+// Snapshot 所有连接的快照
+func (r *ClientRegistry) Snapshot() []ClientConnectObj {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	list := make([]ClientConnectObj, 0, len(r.addrs))
+	for _, v := range r.conns {
+		for _, c := range v {
+			list = append(list, *c)
+		}
+	}
+	return list
+}
+
+// OnLineTable 在线表的副本 key= name@ip
+func (r *ClientRegistry) OnLineTable() map[string]*ClientConnInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	table := make(map[string]*ClientConnInfo, len(r.onLineTable))
+	for k, v := range r.onLineTable {
+		info := *v
+		table[k] = &info
+	}
+	return table
+}
@@ -0,0 +1,154 @@
+package udp
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestRegistryJoin 同一主机上同名的两个c端交替心跳，只有各自第一次连接与离线后的连接触发重连
+func TestRegistryJoin(t *testing.T) {
+	r := newClientRegistry()
+	a := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1001}
+	b := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1002}
+	ip := a.IP.String()
+	steps := []struct {
+		addr net.Addr
+		want int
+	}{
+		{a, joinNew},
+		{b, joinReconnect},
+		{a, joinRefresh},
+		{b, joinRefresh},
+		{a, joinRefresh},
+	}
+	for i, v := range steps {
+		if got := r.join("sim", ip, v.addr); got != v.want {
+			t.Fatalf("第%d次 %s: join = %d, 应为 %d", i, v.addr, got, v.want)
+		}
+	}
+	if list := r.discard("sim", ip); len(list) != 2 {
+		t.Fatalf("discard %d 个连接", len(list))
+	}
+	if got := r.join("sim", ip, b); got != joinReconnect {
+		t.Fatalf("离线后 join = %d", got)
+	}
+	if got := r.join("other", ip, b); got != joinNew {
+		t.Fatalf("更换name后 join = %d", got)
+	}
+	if _, ok := r.GetFromIP("sim", ip); ok {
+		t.Fatal("更换name后旧的记录应移除")
+	}
+}
+
+// TestRegistryExpire 超时只移除该地址的连接，同一 name+ip 的其他端口仍在线
+func TestRegistryExpire(t *testing.T) {
+	r := newClientRegistry()
+	a := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1001}
+	b := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1002}
+	ip := a.IP.String()
+	r.join("sim", ip, a)
+	r.join("sim", ip, b)
+	now := time.Now().Unix()
+	r.conns["sim"][a.String()].Last = now - HeartbeatTimeLast - 1
+
+	list := r.expire(now - HeartbeatTimeLast)
+	if len(list) != 1 || list[0].Addr.String() != a.String() {
+		t.Fatalf("expire %v", list)
+	}
+	if _, ok := r.GetFromAddr(b.String()); !ok {
+		t.Fatal("同一 name+ip 其他端口的连接被移除")
+	}
+	if _, ok := r.GetFromAddr(a.String()); ok {
+		t.Fatal("超时的连接未移除")
+	}
+	if info := r.OnLineTable()[onLineKey("sim", ip)]; info == nil || !info.Online {
+		t.Fatal("仍有在线端口时不应标记离线")
+	}
+
+	r.conns["sim"][b.String()].Last = now - HeartbeatTimeLast - 1
+	if list := r.expire(now - HeartbeatTimeLast); len(list) != 1 {
+		t.Fatalf("expire %v", list)
+	}
+	if info := r.OnLineTable()[onLineKey("sim", ip)]; info == nil || info.Online {
+		t.Fatal("所有端口超时后应标记离线")
+	}
+	if got := r.join("sim", ip, a); got != joinReconnect {
+		t.Fatalf("离线后 join = %d", got)
+	}
+}
+
+// TestRegistryConcurrent 并发 join, discard, expire 与读取快照，使用 -race 运行
+func TestRegistryConcurrent(t *testing.T) {
+	r := newClientRegistry()
+	names := []string{"a", "b", "c"}
+	addr := func(i int) *net.UDPAddr {
+		return &net.UDPAddr{IP: net.IPv4(10, 0, 0, byte(1+i%4)), Port: 1000 + i%16}
+	}
+	var wg sync.WaitGroup
+	run := func(fn func(i int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				fn(i)
+			}
+		}()
+	}
+	for g := 0; g < 4; g++ {
+		g := g
+		run(func(i int) {
+			a := addr(i + g)
+			r.join(names[(i+g)%len(names)], a.IP.String(), a)
+		})
+	}
+	run(func(i int) {
+		a := addr(i)
+		r.discard(names[i%len(names)], a.IP.String())
+	})
+	run(func(i int) {
+		r.expire(time.Now().Unix() - int64(i%2))
+	})
+	run(func(i int) {
+		for _, c := range r.Snapshot() {
+			c.Name = "changed"
+		}
+		for _, info := range r.OnLineTable() {
+			info.Online = !info.Online
+		}
+		if list, ok := r.GetFromName(names[i%len(names)]); ok {
+			for _, c := range list {
+				c.Last = 0
+			}
+		}
+		if c, ok := r.GetFromIP(names[i%len(names)], ""); ok {
+			c.Name = "changed"
+		}
+		if c, ok := r.GetFromAddr(addr(i).String()); ok {
+			c.Name = "changed"
+		}
+		r.Range(func(c ClientConnectObj) bool {
+			return true
+		})
+		r.Names()
+	})
+	wg.Wait()
+
+	// 并发修改后索引仍一致，快照的修改不影响注册表
+	for _, c := range r.Snapshot() {
+		if c.Name == "changed" || c.Last == 0 {
+			t.Fatalf("快照的修改影响了注册表 %+v", c)
+		}
+		if name, ok := r.addrs[c.Addr.String()]; !ok || name != c.Name {
+			t.Fatalf("地址索引不一致 %s -> %s, 应为 %s", c.Addr, name, c.Name)
+		}
+	}
+	total := 0
+	for _, v := range r.conns {
+		total += len(v)
+	}
+	if total != len(r.addrs) {
+		t.Fatalf("连接 %d 个, 地址索引 %d 个", total, len(r.addrs))
+	}
+}
@@ -0,0 +1,220 @@
+package udp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mangenotwork/udp_comm/simnet"
+)
+
+// lossy 丢包、重复、乱序并带有延迟抖动的网络
+var lossy = simnet.Conf{
+	Loss:      0.2,
+	Duplicate: 0.1,
+	Reorder:   0.1,
+	Delay:     time.Millisecond,
+	Jitter:    2 * time.Millisecond,
+}
+
+// received 记录收到的数据，重复收到只记一次
+type received struct {
+	mu   sync.Mutex
+	data map[string]int
+}
+
+func newReceived() *received {
+	return &received{data: make(map[string]int)}
+}
+
+func (r *received) add(b []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.data[string(b)]++
+}
+
+func (r *received) has(b string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.data[b] > 0
+}
+
+func (r *received) len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.data)
+}
+
+// simPair 在模拟网络上创建并运行一对 Servers 与 Client，测试结束时关闭
+func simPair(t *testing.T, network *simnet.Network, setup func(s *Servers, c *Client)) (*Servers, *Client) {
+	t.Helper()
+	return simPairConf(t, network, nil, setup)
+}
+
+// simPairConf 与 simPair 相同，conf 在创建前修改两端的配置
+func simPairConf(t *testing.T, network *simnet.Network, conf func(sConf *ServersConf, cConf *ClientConf),
+	setup func(s *Servers, c *Client)) (*Servers, *Client) {
+	t.Helper()
+	sConn, err := network.Listen("servers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cConn, err := network.Listen("client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sConf := ServersConf{
+		Name:        DefaultServersName,
+		ConnectCode: DefaultConnectCode,
+		SecretKey:   DefaultSecretKey,
+	}
+	cConf := ClientConf{
+		Name:         "sim",
+		ConnectCode:  DefaultConnectCode,
+		SecretKey:    DefaultSecretKey,
+		BacklogStore: NewMemoryBacklogStore(),
+		Heartbeat:    200 * time.Millisecond,
+		Reconnect: ReconnectPolicy{
+			InitialDelay: 20 * time.Millisecond,
+			MaxDelay:     100 * time.Millisecond,
+		},
+	}
+	if conf != nil {
+		conf(&sConf, &cConf)
+	}
+	s, err := NewServersWithConn(sConn, sConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewClientWithConn(cConn, []net.Addr{sConn.LocalAddr()}, cConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setup(s, c)
+	go func() {
+		_ = s.Run()
+	}()
+	go func() {
+		_ = c.Run()
+	}()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = c.Shutdown(ctx)
+		_ = s.Shutdown(ctx)
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return s, c
+}
+
+// waitFor 等待直到 cond 成立，超时则测试失败
+func waitFor(t *testing.T, timeout time.Duration, msg string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestPutDelivery 弱网下put的数据最终全部送达，积压清空
+func TestPutDelivery(t *testing.T) {
+	network := simnet.New(lossy, 1)
+	got := newReceived()
+	_, c := simPair(t, network, func(s *Servers, c *Client) {
+		s.PutHandleFunc("case", func(s *Servers, c *ClientInfo, body []byte) {
+			got.add(body)
+		})
+	})
+	total := 50
+	for i := 0; i < total; i++ {
+		c.Put("case", []byte(fmt.Sprintf("put-%d", i)))
+	}
+	waitFor(t, 10*time.Second, "积压数据没有全部送达", func() bool {
+		return c.BacklogLen() == 0
+	})
+	if got.len() != total {
+		t.Fatalf("servers收到 %d 条, 应为 %d", got.len(), total)
+	}
+	if stats := network.Stats(); stats.Lost == 0 || stats.Duplicated == 0 || stats.Reordered == 0 {
+		t.Fatalf("网络状况没有生效 %+v", stats)
+	}
+}
+
+// TestNoticeRetry 弱网下通知经过重试后全部送达
+func TestNoticeRetry(t *testing.T) {
+	network := simnet.New(lossy, 2)
+	got := newReceived()
+	s, _ := simPair(t, network, func(s *Servers, c *Client) {
+		c.NoticeHandleFunc("case", func(c *Client, data []byte) {
+			got.add(data)
+		})
+	})
+	total := 50
+	var wg sync.WaitGroup
+	errs := make(chan error, total)
+	for i := 0; i < total; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := s.Notice("sim", "case", []byte(fmt.Sprintf("notice-%d", i)), s.SetNoticeRetry(50, 20))
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// c端先应答再执行通知的处理方法
+	waitFor(t, time.Second, "client没有收到全部通知", func() bool {
+		return got.len() == total
+	})
+}
+
+// TestBacklogReplay 网络分区期间put的数据被积压，恢复后重连并全部重传
+func TestBacklogReplay(t *testing.T) {
+	network := simnet.New(simnet.Conf{}, 3)
+	got := newReceived()
+	_, c := simPair(t, network, func(s *Servers, c *Client) {
+		s.PutHandleFunc("case", func(s *Servers, c *ClientInfo, body []byte) {
+			got.add(body)
+		})
+	})
+	network.Partition(simnet.Addr("servers"), simnet.Addr("client"))
+	waitFor(t, 5*time.Second, "分区后client没有判定servers丢失", func() bool {
+		return c.State() == StateDisconnected || c.State() == StateConnecting
+	})
+	total := 100
+	for i := 0; i < total; i++ {
+		c.Put("case", []byte(fmt.Sprintf("backlog-%d", i)))
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := c.BacklogLen(); n != int64(total) {
+		t.Fatalf("分区期间积压 %d 条, 应为 %d", n, total)
+	}
+	if got.len() != 0 {
+		t.Fatal("分区期间servers收到了数据")
+	}
+	network.Heal(simnet.Addr("servers"), simnet.Addr("client"))
+	waitFor(t, 10*time.Second, "恢复后积压数据没有全部重传", func() bool {
+		return c.BacklogLen() == 0
+	})
+	if got.len() != total {
+		t.Fatalf("servers收到 %d 条, 应为 %d", got.len(), total)
+	}
+	if c.State() != StateConnected {
+		t.Fatalf("恢复后连接状态 %s", c.State())
+	}
+}
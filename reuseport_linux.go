@@ -0,0 +1,23 @@
+//go:build linux
+
+package udp
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const reusePortSupported = true
+
+// reusePortControl 设置 SO_REUSEPORT，多个socket绑定同一端口，由内核按来源地址分发数据包
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	var sErr error
+	err := c.Control(func(fd uintptr) {
+		sErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sErr
+}
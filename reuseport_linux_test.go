@@ -0,0 +1,108 @@
+//go:build linux
+
+package udp
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordConn 记录每个socket收包与发包的来源、目的地址
+type recordConn struct {
+	net.PacketConn
+	mu    sync.Mutex
+	read  map[string]int
+	write map[string]int
+}
+
+func (c *recordConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(p)
+	if err == nil {
+		c.mu.Lock()
+		c.read[addr.String()]++
+		c.mu.Unlock()
+	}
+	return n, addr, err
+}
+
+func (c *recordConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	c.mu.Lock()
+	c.write[addr.String()]++
+	c.mu.Unlock()
+	return c.PacketConn.WriteTo(p, addr)
+}
+
+// TestReusePortReplySocket 多个 SO_REUSEPORT socket 时，应答从收到请求的socket发出
+func TestReusePortReplySocket(t *testing.T) {
+	s, err := NewServers("127.0.0.1", 0, ServersConf{
+		Name:        DefaultServersName,
+		ConnectCode: DefaultConnectCode,
+		SecretKey:   DefaultSecretKey,
+		Sockets:     4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.conns) != 4 {
+		t.Fatalf("打开了 %d 个socket", len(s.conns))
+	}
+	records := make([]*recordConn, len(s.conns))
+	for i, conn := range s.conns {
+		records[i] = &recordConn{PacketConn: conn, read: map[string]int{}, write: map[string]int{}}
+		s.conns[i] = records[i]
+	}
+	s.Conn = s.conns[0]
+	s.GetHandleFunc("echo", func(s *Servers, param []byte) (int, []byte) {
+		return 0, param
+	})
+	runSim(t, s, nil)
+
+	port := s.Conn.LocalAddr().(*net.UDPAddr).Port
+	for i := 0; i < 8; i++ {
+		c, err := NewClient(net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), ClientConf{
+			Name:         "reuse",
+			ConnectCode:  DefaultConnectCode,
+			SecretKey:    DefaultSecretKey,
+			BacklogStore: NewMemoryBacklogStore(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		runSim(t, nil, c)
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		err = c.Connect(ctx)
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		param := []byte(strconv.Itoa(i))
+		rse, err := c.Get("echo", param)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(rse) != string(param) {
+			t.Fatalf("get 应答 %s", rse)
+		}
+	}
+
+	used := 0
+	for i, r := range records {
+		r.mu.Lock()
+		if len(r.read) > 0 {
+			used++
+		}
+		for addr, n := range r.write {
+			if r.read[addr] == 0 {
+				t.Errorf("socket %d 向 %s 发送了 %d 个包，但没有收到过它的包", i, addr, n)
+			}
+		}
+		r.mu.Unlock()
+	}
+	if used < 2 {
+		t.Fatalf("只有 %d 个socket收到了数据包", used)
+	}
+}
@@ -1,22 +1,45 @@
 package udp
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Servers struct {
-	Addr        string                                  // 地址 默认0.0.0.0
-	Port        int                                     // 端口
-	Conn        *net.UDPConn                            // S端的UDP连接对象
-	name        string                                  // servers端的名称
-	CMap        map[string]map[string]*ClientConnectObj // 存放客户端连接信息  map:name -> map:ipaddr -> obj
-	connectCode string                                  // 连接code 是静态的由server端配发
-	secretKey   string                                  // 数据传输加密解密秘钥
-	PutHandle   ServersPutFunc                          // PUT类型方法
-	GetHandle   ServersGetFunc                          // GET类型方法
-	onLineTable map[string]*ClientConnInfo              // c端的在线表 key= name+ip
+	Addr        string                          // 地址 为空时监听所有地址(双栈)
+	Port        int                             // 端口
+	Conn        net.PacketConn                  // S端的连接对象，默认为UDP
+	name        string                          // servers端的名称
+	Clients     *ClientRegistry                 // 存放客户端连接信息与在线表
+	connectCode string                          // 连接code 是静态的由server端配发
+	secretKey   string                          // 数据传输加密解密秘钥
+	cipher      CipherSuite                     // 数据传输加密套件
+	strict      bool                            // 不接受使用DES的旧版本c端
+	codec       CodecType                       // 信封结构的编码，c端未知时使用
+	compress    CompressType                    // data的压缩算法
+	compressMin int                             // 小于该字节数的数据不压缩
+	conns       []net.PacketConn                // 所有接收数据的socket, Conn 为第一个
+	peers       sync.Map                        // c端的通讯信息 key= ip+port -> *peerInfo
+	PutHandle   ServersPutFunc                  // PUT类型方法
+	putHandle   ServersPutContextFunc           // 带ctx与应答状态的PUT类型方法
+	GetHandle   ServersGetFunc                  // GET类型方法
+	fragment    *fragmentPool                   // 分片重组池
+	done        chan struct{}                   // Shutdown时关闭，通知时间轮退出
+	closed      int32                           // 1:已关闭
+	runLock     sync.Mutex                      // Run 启动协程与 Shutdown 互斥，Shutdown 之后不再 wg.Add
+	wg          sync.WaitGroup                  // 读取循环，时间轮与处理中的请求
+	hook        serversHook                     // 连接生命周期的回调
+	pool        *workerPool                     // 处理收到的数据包
+	transport   TransportMode                   // 收发数据包的方式
+	batchSize   int                             // 批量收发时一次系统调用的最大包数
+	batchLinger time.Duration                   // 批量发送时等待凑满一批的最长时间
+	writers     map[net.PacketConn]*batchWriter // 批量发送，key为socket
 }
 
 type ClientConnInfo struct {
@@ -29,9 +52,44 @@ type ClientConnInfo struct {
 }
 
 type ServersConf struct {
-	Name        string // servers端的名称
-	ConnectCode string // 连接code 是静态的由server端配发
-	SecretKey   string // 数据传输加密解密秘钥 8个字节
+	Name        string      // servers端的名称
+	ConnectCode string      // 连接code 是静态的由server端配发
+	SecretKey   string      // 数据传输加密解密秘钥 8个字节，Cipher 为 CipherAESGCM 时也可以为32个字节(8个字节时AES的强度只有64位)
+	Cipher      CipherSuite // 数据传输加密套件 默认DES，同时兼容使用DES的旧版本c端
+	Codec       CodecType   // 信封结构的编码 默认CodecBinary，应答时使用c端的编码，同时兼容使用JSON的旧版本c端
+
+	// StrictCipher 只接受 Cipher 配置的加密套件，不再兼容使用DES的旧版本c端 默认false
+	// 秘钥为32个字节时无法使用DES，总是不接受
+	StrictCipher bool
+
+	// Compress data的压缩算法 默认CompressZlib，接收时按包头中的算法解压，发往v0 c端时总是使用zlib
+	Compress CompressType
+	// CompressMin 小于该字节数的数据不压缩 默认64，小于0时总是压缩
+	CompressMin int
+
+	Workers   int            // 处理数据包的协程数 默认64
+	QueueSize int            // 等待处理的数据包队列长度 默认1024
+	Overflow  OverflowPolicy // 队列满时的策略 默认丢弃
+
+	// Sockets 在同一端口上打开的socket数量，每个socket有独立的读取循环 默认1
+	// 大于1时使用 SO_REUSEPORT 只支持Linux，其他系统只打开一个socket
+	Sockets int
+
+	// Transport 收发数据包的方式 默认 TransportStd
+	// TransportBatch 使用 recvmmsg/sendmmsg，addr 为IPv4地址(含0.0.0.0)时只监听IPv4以便批量发送给IPv4的c端
+	Transport TransportMode
+	BatchSize int // 批量收发时一次系统调用的最大包数 默认64
+	// BatchLinger 批量发送时等待凑满一批的最长时间 默认0，队列为空就发送
+	// 大于0时负载不高也能凑成批，减少系统调用，但每个包最多延迟 BatchLinger
+	BatchLinger time.Duration
+}
+
+// peerInfo c端的通讯信息，应答时使用相同的加密套件、包头版本与编码，并从收包的socket发出
+type peerInfo struct {
+	cipher  CipherSuite
+	version uint8
+	codec   CodecType
+	conn    net.PacketConn
 }
 
 func SetServersConf(serversName, connectCode, secretKey string) ServersConf {
@@ -43,54 +101,176 @@ func SetServersConf(serversName, connectCode, secretKey string) ServersConf {
 }
 
 func NewServers(addr string, port int, conf ...ServersConf) (*Servers, error) {
-	var err error
-	if len(addr) < 1 {
-		addr = "0.0.0.0"
+	s, err := newServers(conf...)
+	if err != nil {
+		return nil, err
+	}
+	s.Addr, s.Port = addr, port
+	sockets := 1
+	if len(conf) >= 1 {
+		sockets = conf[0].Sockets
+	}
+	// addr 为空时监听所有地址(IPv4与IPv6双栈)，支持域名与IPv6地址
+	lAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(s.Addr, strconv.Itoa(s.Port)))
+	if err != nil {
+		Error(err)
+		return nil, err
 	}
+	if err = s.listen(lAddr, sockets); err != nil {
+		Error(err)
+		return nil, err
+	}
+	s.start()
+	return s, nil
+}
+
+// NewServersWithConn 使用已创建的连接，可以是任意 net.PacketConn，如unix datagram socket、内存管道或包装了统计的连接
+// Sockets 配置不生效，Transport 为 TransportBatch 时只对 *net.UDPConn 生效
+func NewServersWithConn(conn net.PacketConn, conf ...ServersConf) (*Servers, error) {
+	s, err := newServers(conf...)
+	if err != nil {
+		return nil, err
+	}
+	s.Conn = conn
+	s.conns = []net.PacketConn{conn}
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+		s.Addr, s.Port = addr.IP.String(), addr.Port
+	} else {
+		s.Addr = conn.LocalAddr().String()
+	}
+	s.start()
+	return s, nil
+}
+
+func newServers(conf ...ServersConf) (*Servers, error) {
 	s := &Servers{
-		Addr:        addr,
-		Port:        port,
-		CMap:        make(map[string]map[string]*ClientConnectObj),
+		batchSize:   DefaultBatchSize,
+		batchLinger: DefaultBatchLinger * time.Microsecond,
+		compressMin: DefaultCompressMin,
+		Clients:     newClientRegistry(),
 		PutHandle:   make(ServersPutFunc),
+		putHandle:   make(ServersPutContextFunc),
 		GetHandle:   make(ServersGetFunc),
-		onLineTable: make(map[string]*ClientConnInfo),
+		fragment:    newFragmentPool(),
+		done:        make(chan struct{}),
 	}
 	if len(conf) >= 1 {
-		if len(conf[0].Name) > 0 && len(conf[0].Name) <= 7 {
-			s.name = conf[0].Name
+		if err := checkName(conf[0].Name); err != nil {
+			return nil, err
 		}
-		if len(conf[0].Name) > 7 {
-			return nil, ErrNmeLengthAbove
+		if len(conf[0].Name) > 0 {
+			s.name = conf[0].Name
 		}
 		if len(conf[0].ConnectCode) > 0 {
 			s.connectCode = conf[0].ConnectCode
 		}
-		if len(conf[0].SecretKey) != 8 {
+		if !checkSecretKey(conf[0].SecretKey, conf[0].Cipher) {
 			return nil, ErrServersSecretKey
 		} else {
 			s.secretKey = conf[0].SecretKey
 		}
+		s.cipher = conf[0].Cipher
+		s.strict = conf[0].StrictCipher
+		if _, err := getCodec(conf[0].Codec); err != nil {
+			return nil, err
+		}
+		s.codec = conf[0].Codec
+		if _, err := getCompressor(conf[0].Compress); err != nil {
+			return nil, err
+		}
+		s.compress = conf[0].Compress
+		s.compressMin = compressMin(conf[0].CompressMin)
+		s.pool = newWorkerPool(conf[0].Workers, conf[0].QueueSize, conf[0].Overflow, s.process)
+		s.transport = conf[0].Transport
+		if conf[0].BatchSize > 0 {
+			s.batchSize = conf[0].BatchSize
+		}
+		if conf[0].BatchLinger > 0 {
+			s.batchLinger = conf[0].BatchLinger
+		}
 	} else {
 		s.DefaultServersName()
 		s.DefaultConnectCode()
 		s.DefaultSecretKey()
+		s.pool = newWorkerPool(DefaultWorkers, DefaultQueueSize, OverflowDrop, s.process)
 	}
-	s.Conn, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(s.Addr), Port: s.Port})
-	if err != nil {
-		Error(err)
-		return nil, err
-	}
-	InfoF("udp server 启动成功 -->  name:%s |  addr: %s  | conn_code: %s \n",
-		s.name, s.Conn.LocalAddr().String(), s.connectCode)
 	return s, nil
 }
 
-func (s *Servers) SetServersName(name string) error {
-	if len(name) > 0 && len(name) <= 7 {
-		s.name = name
+// start 连接创建后启动批量发送
+func (s *Servers) start() {
+	if s.transport == TransportBatch {
+		s.writers = make(map[net.PacketConn]*batchWriter, len(s.conns))
+		for _, pc := range s.conns {
+			conn, ok := pc.(*net.UDPConn)
+			if !ok {
+				// 非UDP连接不支持批量收发
+				continue
+			}
+			w := newBatchWriter(conn, s.batchSize, s.batchLinger)
+			s.writers[conn] = w
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				w.run(s.done)
+			}()
+		}
+	}
+	InfoF("udp server 启动成功 -->  name:%s |  addr: %s  | conn_code: %s | sockets: %d \n",
+		s.name, s.Conn.LocalAddr().String(), s.connectCode, len(s.conns))
+}
+
+// listen 打开socket, sockets 大于1时使用 SO_REUSEPORT 在同一端口打开多个socket
+func (s *Servers) listen(lAddr *net.UDPAddr, sockets int) error {
+	if sockets > 1 && !reusePortSupported {
+		Error("当前系统不支持 SO_REUSEPORT, 只打开一个socket")
+		sockets = 1
+	}
+	network := "udp"
+	if s.transport == TransportBatch && lAddr.IP.To4() != nil {
+		// 双栈socket无法批量发送给IPv4地址
+		network = "udp4"
+	}
+	if sockets <= 1 {
+		conn, err := net.ListenUDP(network, lAddr)
+		if err != nil {
+			return err
+		}
+		s.Conn = conn
+		s.conns = []net.PacketConn{conn}
 		return nil
 	}
-	return ErrNmeLengthAbove
+	lc := net.ListenConfig{Control: reusePortControl}
+	address := lAddr.String()
+	for i := 0; i < sockets; i++ {
+		pc, err := lc.ListenPacket(context.Background(), network, address)
+		if err != nil {
+			for _, c := range s.conns {
+				_ = c.Close()
+			}
+			s.conns = nil
+			return err
+		}
+		if i == 0 {
+			// 端口为0时由系统分配，其余socket绑定相同的端口
+			address = pc.LocalAddr().String()
+			s.Conn = pc
+		}
+		s.conns = append(s.conns, pc)
+	}
+	return nil
+}
+
+// SetServersName 名称最多64个字节，发往使用v0包头的c端时截断为7个字节
+func (s *Servers) SetServersName(name string) error {
+	if len(name) == 0 {
+		return ErrNmeLengthAbove
+	}
+	if err := checkName(name); err != nil {
+		return err
+	}
+	s.name = name
+	return nil
 }
 
 func (s *Servers) SetConnectCode(code string) {
@@ -98,142 +278,337 @@ func (s *Servers) SetConnectCode(code string) {
 }
 
 func (s *Servers) SetSecretKey(key string) error {
-	if len(key) != 8 {
+	if !checkSecretKey(key, s.cipher) {
 		return ErrServersSecretKey
 	}
 	s.secretKey = key
 	return nil
 }
 
-func (s *Servers) Run() {
+// SetCipher 秘钥为32个字节时只能使用 CipherAESGCM
+func (s *Servers) SetCipher(cipher CipherSuite) error {
+	if !checkSecretKey(s.secretKey, cipher) {
+		return ErrServersSecretKey
+	}
+	s.cipher = cipher
+	return nil
+}
 
+// Run 启动servers, 阻塞直到 Shutdown 被调用，返回 ErrServersClosed
+// 连接被外部关闭时返回读取的错误(net.ErrClosed)，仍需调用 Shutdown 停止时间轮
+func (s *Servers) Run() error {
+	s.runLock.Lock()
+	if s.isClosed() {
+		s.runLock.Unlock()
+		return ErrServersClosed
+	}
+	s.wg.Add(1)
+	defer s.wg.Done()
 	// 启动一个时间轮维护c端的连接
 	s.timeWheel()
+	// 读取循环只负责收包，解包与处理交给固定数量的协程
+	s.pool.start(&s.wg)
+	s.runLock.Unlock()
+
+	var (
+		readers sync.WaitGroup
+		errOnce sync.Once
+		runErr  error = ErrServersClosed
+	)
+	for _, conn := range s.conns {
+		readers.Add(1)
+		go func(conn net.PacketConn) {
+			defer readers.Done()
+			var err error
+			if udpConn, ok := conn.(*net.UDPConn); ok && s.transport == TransportBatch {
+				err = s.readLoopBatch(udpConn)
+			} else {
+				err = s.readLoop(conn)
+			}
+			if err != nil {
+				errOnce.Do(func() {
+					runErr = err
+				})
+			}
+		}(conn)
+	}
+	readers.Wait()
+	s.pool.stop()
+	return runErr
+}
 
-	data := make([]byte, 1500)
+// readLoop 一个socket的读取循环，Shutdown 后返回nil，连接被外部关闭时返回错误
+func (s *Servers) readLoop(conn net.PacketConn) error {
 	for {
-		n, remoteAddr, err := s.Conn.ReadFromUDP(data)
+		buf := packetBufPool.Get().(*[]byte)
+		n, remoteAddr, err := conn.ReadFrom(*buf)
 		if err != nil {
+			packetBufPool.Put(buf)
+			if s.isClosed() {
+				return nil
+			}
 			Error(err)
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
 			continue
 		}
-		//Info("解包....size = ", n)
-		packet, err := PacketDecrypt(s.secretKey, data, n)
+		s.pool.submit(&inPacket{buf: buf, n: n, addr: remoteAddr, conn: conn})
+	}
+}
+
+// process 解包并处理一个收到的数据包，由处理协程调用
+func (s *Servers) process(in *inPacket) {
+	remoteAddr, n := in.addr, in.n
+	//Info("解包....size = ", n)
+	head, err := packetHeader(in.data(), n)
+	if err != nil {
+		// 不是本协议的包或已损坏，不做应答
+		Error("错误的包 err:", err)
+		s.fireClientErr(&s.hook.unknownPacket, newClientInfo("", remoteAddr, n), err)
+		return
+	}
+	packet, err := packetDecode(head, in.data()[:n], &packetConf{secret: s.secretKey, cipher: s.cipher, strict: s.strict})
+	if err != nil {
+		Error("错误的包 err:", err)
+		s.fireClientErr(&s.hook.unknownPacket, newClientInfo("", remoteAddr, n), err)
+		if head.command == CommandConnect || head.command == CommandHeartbeat {
+			// 连接包无法解密，明确拒绝，c端用自己的秘钥也无法解密该应答，据此判断秘钥不一致
+			s.replyConnectErr(remoteAddr, ReplyStateSecretErr, head.version, head.codec)
+		}
+		return
+	}
+	in.release()
+	s.storePeer(remoteAddr, in.conn, packet)
+	if packet.Command == CommandFragment {
+		// 连接与心跳不会分片，其余指令的分片先校验签名再存入重组池，未认证的来源不能占用重组的内存
+		if !SignCheck(remoteAddr.String(), packet.Sign) {
+			Error("分片包签名认证失败")
+			s.fireClientErr(&s.hook.authFailure, newClientInfo(packet.Name, remoteAddr, n), ErrSignCheck)
+			return
+		}
+		name := packet.Name
+		packet, err = s.fragment.add(remoteAddr.String(), packet)
 		if err != nil {
-			Error("错误的包 err:", err)
-			continue
+			Error("错误的分片包 err:", err)
+			s.fireClientErr(&s.hook.unknownPacket, newClientInfo(name, remoteAddr, n), err)
+			return
 		}
-		go func() {
-			switch packet.Command {
-			case CommandConnect, CommandHeartbeat:
-				if string(packet.Data) != s.connectCode {
-					Error("未知客户端，连接code不正确...")
-					return
-				}
-				// 存储c端的连接
-				s.clientJoin(packet.Name, remoteAddr.IP.String(), remoteAddr)
-				// 下发签名
-				s.replyConnect(remoteAddr)
-
-			case CommandPut:
-				if !SignCheck(remoteAddr.String(), packet.Sign) {
-					s.ReplyPut(remoteAddr, 0, 1)
-				} else {
-					putData := &PutData{}
-					bErr := ByteToObj(packet.Data, &putData)
-					if bErr != nil {
-						Error("解析put err :", bErr)
-					}
-					if fn, ok := s.PutHandle[putData.Label]; ok {
-						cInfo := &ClientInfo{
-							Name:        packet.Name,
-							Addr:        remoteAddr,
-							Interactive: time.Now().Unix(),
-							PacketSize:  n,
-						}
-						fn(s, cInfo, putData.Body)
-					}
-					s.ReplyPut(remoteAddr, putData.Id, 0)
-				}
+		if packet == nil { // 分片未到齐
+			return
+		}
+	}
+	s.handle(packet, remoteAddr, n)
+}
 
-			case CommandGet:
-				if !SignCheck(remoteAddr.String(), packet.Sign) {
-					s.ReplyPut(remoteAddr, 0, 1)
-				} else {
-					getData := &GetData{}
-					boErr := ByteToObj(packet.Data, &getData)
-					if boErr != nil {
-						Error("解析put err :", boErr)
-					}
-					if fn, ok := s.GetHandle[getData.Label]; ok {
-						code, rse := fn(s, getData.Param)
-						getData.Response = rse
-						gb, gbErr := ObjToByte(getData)
-						if gbErr != nil {
-							Error("对象转字节错误...")
-						}
-						s.ReplyGet(remoteAddr, getData.Id, code, gb)
-					}
-				}
+// Stats 收到的数据包与丢弃的数据包统计
+func (s *Servers) Stats() Stats {
+	return s.pool.stats()
+}
 
-			case CommandNotice:
-				if !SignCheck(remoteAddr.String(), packet.Sign) {
-					s.ReplyPut(remoteAddr, 0, 1)
-				} else {
-					notice := &NoticeData{}
-					bErr := ByteToObj(packet.Data, &notice)
-					if bErr != nil {
-						Error("返回的包解析失败， err = ", bErr)
-					}
-					if v, ok := NoticeDataMap.Load(notice.Id); ok {
-						if v != nil {
-							v.(*NoticeData).ctxChan <- true
-						}
-					}
-				}
+func (s *Servers) isClosed() bool {
+	return atomic.LoadInt32(&s.closed) == 1
+}
 
-			case CommandReply:
-				if !SignCheck(remoteAddr.String(), packet.Sign) {
-					s.ReplyPut(remoteAddr, 0, 1)
-					break
-				}
-				reply := &Reply{}
-				bErr := ByteToObj(packet.Data, &reply)
-				if bErr != nil {
-					Error("返回的包解析失败， err = ", bErr)
+// Shutdown 优雅关闭: 停止接收数据包，等待处理中的请求完成，停止时间轮，最后关闭连接
+// ctx结束时不再等待，直接关闭连接并返回ctx的错误
+func (s *Servers) Shutdown(ctx context.Context) error {
+	s.runLock.Lock()
+	if !atomic.CompareAndSwapInt32(&s.closed, 0, 1) {
+		s.runLock.Unlock()
+		return ErrServersClosed
+	}
+	close(s.done)
+	s.runLock.Unlock()
+	// 使阻塞中的读取立即返回
+	for _, conn := range s.conns {
+		_ = conn.SetReadDeadline(time.Now())
+	}
+	wait := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(wait)
+	}()
+	var err error
+	select {
+	case <-wait:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	for _, conn := range s.conns {
+		if cErr := conn.Close(); err == nil {
+			err = cErr
+		}
+	}
+	return err
+}
+
+func (s *Servers) handle(packet *Packet, remoteAddr net.Addr, n int) {
+	switch packet.Command {
+	case CommandConnect, CommandHeartbeat:
+		if string(packet.Data) != s.connectCode {
+			Error("未知客户端，连接code不正确...")
+			s.fireClientErr(&s.hook.authFailure, newClientInfo(packet.Name, remoteAddr, n), ErrConnectCode)
+			s.replyConnectErr(remoteAddr, ReplyStateConnectCodeErr, packet.Version, packet.Codec)
+			return
+		}
+		// 存储c端的连接
+		switch s.Clients.join(packet.Name, addrIP(remoteAddr), remoteAddr) {
+		case joinNew:
+			s.fireClient(&s.hook.connect, newClientInfo(packet.Name, remoteAddr, n))
+		case joinReconnect:
+			s.fireClient(&s.hook.reconnect, newClientInfo(packet.Name, remoteAddr, n))
+		}
+		// 下发签名
+		s.replyConnect(remoteAddr)
+
+	case CommandPut:
+		putData := &PutData{}
+		bErr := decodeObj(packet.Codec, packet.Data, &putData)
+		if bErr != nil {
+			Error("解析put err :", bErr)
+		}
+		if !SignCheck(remoteAddr.String(), packet.Sign) {
+			// 带上put id, c端据此知道是哪条数据签名失败
+			s.ReplyPut(remoteAddr, putData.Id, ReplyStateSignErr)
+			s.fireClientErr(&s.hook.authFailure, newClientInfo(packet.Name, remoteAddr, n), ErrSignCheck)
+		} else if fn, ok := s.putHandle[putData.Label]; ok {
+			code, rse := fn(withCodec(context.Background(), packet.Codec), s, newClientInfo(packet.Name, remoteAddr, n), putData.Body)
+			s.replyPut(remoteAddr, packet.Sign, putData.Id, code, rse)
+		} else {
+			if fn, ok := s.PutHandle[putData.Label]; ok {
+				fn(s, newClientInfo(packet.Name, remoteAddr, n), putData.Body)
+			}
+			stateB, _ := int64ToBytes(ReplyStateSuccess)
+			s.replyPut(remoteAddr, packet.Sign, putData.Id, ReplyStateSuccess, stateB)
+		}
+
+	case CommandGet:
+		if !SignCheck(remoteAddr.String(), packet.Sign) {
+			s.ReplyPut(remoteAddr, 0, 1)
+			s.fireClientErr(&s.hook.authFailure, newClientInfo(packet.Name, remoteAddr, n), ErrSignCheck)
+		} else {
+			getData := &GetData{}
+			boErr := decodeObj(packet.Codec, packet.Data, &getData)
+			if boErr != nil {
+				Error("解析put err :", boErr)
+			}
+			if fn, ok := s.GetHandle[getData.Label]; ok {
+				ctx, cancel := getData.context()
+				code, rse := fn(withCodec(ctx, packet.Codec), s, getData.Param)
+				cancel()
+				if ctx.Err() == context.DeadlineExceeded {
+					// 请求方已超时，不再应答
+					return
 				}
-				// Info("收到包 id: ", reply.Type)
-				switch CommandCode(reply.Type) {
-				case CommandGet:
-					// InfoF("请求 ID: %d | StateCode: %d", reply.CtxId, reply.StateCode)
-					getData := &GetData{}
-					boErr := ByteToObj(reply.Data, &getData)
-					if boErr != nil {
-						Error("解析put err :", boErr)
-					}
-					getF, _ := GetDataMap.Load(getData.Id)
-					if getF != nil {
-						getF.(*GetData).Response = getData.Response
-						getF.(*GetData).ctxChan <- true
-					}
+				getData.Response = rse
+				gb, gbErr := s.encode(remoteAddr, getData)
+				if gbErr != nil {
+					Error("对象转字节错误...")
 				}
+				s.replyGet(remoteAddr, packet.Sign, getData.Id, code, gb)
+			}
+		}
 
-			default:
-				// 未知包丢弃
-				Error("未知包!!!")
-				return
+	case CommandNotice:
+		if !SignCheck(remoteAddr.String(), packet.Sign) {
+			s.ReplyPut(remoteAddr, 0, 1)
+			s.fireClientErr(&s.hook.authFailure, newClientInfo(packet.Name, remoteAddr, n), ErrSignCheck)
+		} else {
+			notice := &NoticeData{}
+			bErr := decodeObj(packet.Codec, packet.Data, &notice)
+			if bErr != nil {
+				Error("返回的包解析失败， err = ", bErr)
 			}
-		}()
+			noticeDataDone(notice.Id)
+		}
+
+	case CommandReply:
+		if !SignCheck(remoteAddr.String(), packet.Sign) {
+			s.ReplyPut(remoteAddr, 0, 1)
+			s.fireClientErr(&s.hook.authFailure, newClientInfo(packet.Name, remoteAddr, n), ErrSignCheck)
+			break
+		}
+		reply := &Reply{}
+		bErr := decodeObj(packet.Codec, packet.Data, &reply)
+		if bErr != nil {
+			Error("返回的包解析失败， err = ", bErr)
+		}
+		// Info("收到包 id: ", reply.Type)
+		switch CommandCode(reply.Type) {
+		case CommandGet:
+			// InfoF("请求 ID: %d | StateCode: %d", reply.CtxId, reply.StateCode)
+			getData := &GetData{}
+			boErr := decodeObj(packet.Codec, reply.Data, &getData)
+			if boErr != nil {
+				Error("解析put err :", boErr)
+			}
+			getDataDone(getData.Id, reply.StateCode, getData.Response)
+		}
+
+	default:
+		// 未知包丢弃
+		Error("未知包!!!")
+		s.fireClientErr(&s.hook.unknownPacket, newClientInfo(packet.Name, remoteAddr, n), ErrUnknownCommand(packet.Command))
+		return
 	}
 }
 
-func (s *Servers) Write(client *net.UDPAddr, data []byte) {
-	_, err := s.Conn.WriteToUDP(data, client)
+// Write 发送数据到c端，从收到该c端数据包的socket发出
+func (s *Servers) Write(client net.Addr, data []byte) {
+	conn := s.Conn
+	if v, ok := s.peers.Load(client.String()); ok {
+		conn = v.(*peerInfo).conn
+	}
+	if w, ok := s.writers[conn]; ok {
+		w.write(s.done, client, data)
+		return
+	}
+	_, err := conn.WriteTo(data, client)
 	if err != nil {
 		Error(err.Error())
 	}
 }
 
+// send 封包并发送，数据过大时拆分为多个分片包发送
+func (s *Servers) send(client net.Addr, cmd CommandCode, sign string, data []byte) {
+	packets, err := packetEncoderFragment(cmd, s.name, sign, data, s.packetConf(client))
+	if err != nil {
+		Error(err)
+		return
+	}
+	for _, packet := range packets {
+		s.Write(client, packet)
+	}
+}
+
+// packetConf 发往c端的封包配置，使用c端最近一次使用的加密套件与包头版本
+func (s *Servers) packetConf(client net.Addr) *packetConf {
+	conf := &packetConf{secret: s.secretKey, cipher: s.cipher, strict: s.strict, version: PacketV1, codec: s.codec,
+		compress: s.compress, compressMin: s.compressMin}
+	if v, ok := s.peers.Load(client.String()); ok {
+		conf.cipher = v.(*peerInfo).cipher
+		conf.version = v.(*peerInfo).version
+		conf.codec = v.(*peerInfo).codec
+	}
+	return conf
+}
+
+// encode 使用c端的编码编码信封结构
+func (s *Servers) encode(client net.Addr, obj interface{}) ([]byte, error) {
+	return encodeObj(s.packetConf(client).codec, obj)
+}
+
+// storePeer 记录c端使用的加密套件、包头版本、编码与收包的socket
+func (s *Servers) storePeer(client net.Addr, conn net.PacketConn, packet *Packet) {
+	key := client.String()
+	peer := &peerInfo{cipher: packet.Cipher, version: packet.Version, codec: packet.Codec, conn: conn}
+	if v, ok := s.peers.Load(key); ok && *v.(*peerInfo) == *peer {
+		return
+	}
+	s.peers.Store(key, peer)
+}
+
 func (s *Servers) Get(funcLabel, name string, param []byte) ([]byte, error) {
 	return s.GetAtNameTimeOut(DefaultSGetTimeOut, funcLabel, name, param)
 }
@@ -252,37 +627,37 @@ func (s *Servers) GetAtIPTimeOut(timeOut int, funcLabel, name, ip string, param
 
 // Get  向指定 client获取数据，  针对name,ip, 获取指定name或ip Client的数据
 func (s *Servers) get(timeOut int, funcLabel, name, ip string, param []byte) ([]byte, error) {
-	getData := &GetData{
-		Label:    funcLabel,
-		Id:       id(),
-		Param:    param,
-		ctxChan:  make(chan bool),
-		Response: make([]byte, 0),
-	}
-	GetDataMap.Store(getData.Id, getData)
-	b, err := ObjToByte(getData)
-	if err != nil {
-		Error("ObjToByte err = ", err)
-	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*time.Duration(timeOut))
+	defer cancel()
+	return s.GetContext(ctx, funcLabel, name, ip, param)
+}
 
+// GetContext 向指定 client获取数据，ip为空时取name下的任意一个连接
+// ctx的截止时间会传给c端的handler，ctx结束时返回超时或取消的错误，handler 返回非0的状态码时返回 *ReplyError
+func (s *Servers) GetContext(ctx context.Context, funcLabel, name, ip string, param []byte) ([]byte, error) {
 	c, ok := s.GetClientConnFromIP(name, ip)
 	if !ok {
 		return nil, fmt.Errorf("客户端连接不存在")
 	}
-	sign := SignGet(c.String())
-	packet, err := PacketEncoder(CommandGet, s.name, sign, s.secretKey, b)
+	getData := newGetData(ctx, funcLabel, param)
+	GetDataMap.Store(getData.Id, getData)
+	defer GetDataMap.Delete(getData.Id)
+	b, err := s.encode(c, getData)
 	if err != nil {
-		Error(err)
+		return nil, err
 	}
-	s.Write(c, packet)
+	s.send(c, CommandGet, SignGet(c.String()), b)
 	select {
-	case <-getData.ctxChan:
-		res := getData.Response
-		GetDataMap.Delete(getData.Id)
-		return res, nil
-	case <-time.After(time.Millisecond * time.Duration(timeOut)):
-		GetDataMap.Delete(getData.Id)
-		return nil, ErrSGetTimeOut(funcLabel, name, ip)
+	case res := <-getData.ctxChan:
+		if err = replyError(res.state, res.response); err != nil {
+			return nil, err
+		}
+		return res.response, nil
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, ErrSGetTimeOut(funcLabel, name, ip)
+		}
+		return nil, ctx.Err()
 	}
 }
 
@@ -313,6 +688,13 @@ func (s *Servers) NoticeAll(label string, data []byte, retryConf *NoticeRetry) {
 // Notice  通知方法:针对 name,对Client发送通知
 // 特点: 1. 重试次数 2. 指定时间内重试
 func (s *Servers) Notice(name, label string, data []byte, retryConf *NoticeRetry) (string, error) {
+	return s.notice(name, label, func(net.Addr) ([]byte, error) {
+		return data, nil
+	}, retryConf)
+}
+
+// notice 下发通知，data 按c端的地址生成通知内容，c端的编码可能不同
+func (s *Servers) notice(name, label string, data func(addr net.Addr) ([]byte, error), retryConf *NoticeRetry) (string, error) {
 	if name == "" {
 		name = formatName(DefaultClientName)
 	}
@@ -325,16 +707,22 @@ func (s *Servers) Notice(name, label string, data []byte, retryConf *NoticeRetry
 		return "未找到客户端", ErrNotFondClient(name)
 	}
 	// 组建通知包
-	packetMap := make(map[*net.UDPAddr]*NoticeData)
+	packetMap := make(map[net.Addr]*NoticeData)
 	for _, c := range client {
-		noticeData := &NoticeData{
+		b, err := data(c.Addr)
+		if err != nil {
+			return "通知内容编码失败", err
+		}
+		packetMap[c.Addr] = &NoticeData{
 			Label:   label,
 			Id:      id(),
-			Data:    data,
-			ctxChan: make(chan bool),
+			Data:    b,
+			ctxChan: make(chan bool, 1),
 		}
+	}
+	for _, noticeData := range packetMap {
+		noticeData := noticeData
 		NoticeDataMap.Store(noticeData.Id, noticeData)
-		packetMap[c.Addr] = noticeData
 		go func() {
 			for {
 				timer := time.NewTimer(retryConf.TimeOutTimer)
@@ -370,22 +758,17 @@ func (s *Servers) Notice(name, label string, data []byte, retryConf *NoticeRetry
 	}
 }
 
-func (s *Servers) noticeSend(packetMap map[*net.UDPAddr]*NoticeData) bool {
+func (s *Servers) noticeSend(packetMap map[net.Addr]*NoticeData) bool {
 	finish := true
 	for cConn, v := range packetMap {
 		_, has := NoticeDataMap.Load(v.Id)
 		if has {
 			finish = false
-			b, err := ObjToByte(v)
+			b, err := s.encode(cConn, v)
 			if err != nil {
-				Error("ObjToByte err = ", err)
+				Error("encode err = ", err)
 			}
-			sign := SignGet(cConn.String())
-			packet, err := PacketEncoder(CommandNotice, s.name, sign, s.secretKey, b)
-			if err != nil {
-				Error(err)
-			}
-			s.Write(cConn, packet)
+			s.send(cConn, CommandNotice, SignGet(cConn.String()), b)
 		}
 	}
 	return finish
@@ -395,10 +778,21 @@ type Reply struct {
 	Type      int
 	CtxId     int64 // 数据包上下文的交互id
 	Data      []byte
-	StateCode int // 状态码  0:成功  1:认证失败  2:自定义错误
+	StateCode int // 状态码  0:成功  1:认证失败  2:自定义错误  3:连接code不正确  4:无法解密  5:解码失败
 }
 
-func (s *Servers) replyConnect(client *net.UDPAddr) {
+// Reply 的状态码
+const (
+	ReplyStateSuccess = 0 // 成功
+	ReplyStateSignErr = 1 // 签名认证失败
+	ReplyStateCustom  = 2 // 业务层面的失败
+
+	ReplyStateConnectCodeErr = 3 // 连接code不正确，拒绝连接
+	ReplyStateSecretErr      = 4 // 无法解密连接包，秘钥或加密套件不一致，拒绝连接
+	ReplyStateDecodeErr      = 5 // 数据解码失败，与处理方法的类型不一致
+)
+
+func (s *Servers) replyConnect(client net.Addr) {
 	sign := createSign()
 	reply := &Reply{
 		Type:      int(CommandConnect),
@@ -406,58 +800,78 @@ func (s *Servers) replyConnect(client *net.UDPAddr) {
 		CtxId:     0,
 		StateCode: 0,
 	}
-	b, e := ObjToByte(reply)
+	b, e := s.encode(client, reply)
+	if e != nil {
+		Error(" e= ", e)
+	}
+	// 存储这个 sign  ip+port:sign
+	SignStore(client.String(), sign)
+	s.send(client, CommandReply, sign, b)
+}
+
+// replyConnectErr 拒绝连接，使用连接包的包头版本与编码应答，编码未注册时使用默认编码
+func (s *Servers) replyConnectErr(client net.Addr, state int, version uint8, codec CodecType) {
+	reply := &Reply{
+		Type:      int(CommandConnect),
+		StateCode: state,
+	}
+	conf := s.packetConf(client)
+	conf.version = version
+	if _, err := getCodec(codec); err == nil {
+		conf.codec = codec
+	}
+	b, e := encodeObj(conf.codec, reply)
 	if e != nil {
 		Error(" e= ", e)
 	}
-	data, err := PacketEncoder(CommandReply, s.name, sign, s.secretKey, b)
+	data, err := packetEncoder(CommandReply, s.name, "", b, conf)
 	if err != nil {
 		Error(err)
+		return
 	}
-	// 存储这个 sign  ip+port:sign
-	SignStore(client.String(), sign)
 	s.Write(client, data)
 }
 
 // ReplyPut  响应put  state:0x0 成功   state:0x1 签名失败
-func (s *Servers) ReplyPut(client *net.UDPAddr, id, state int64) {
+func (s *Servers) ReplyPut(client net.Addr, id, state int64) {
 	stateB, _ := int64ToBytes(state)
+	s.replyPut(client, SignGet(client.String()), id, int(state), stateB)
+}
+
+// replyPut 响应put，处理失败时data为错误信息
+// sign 为请求携带的签名，心跳刚轮换的签名可能还没有送达c端，使用新签名的应答会被c端丢弃
+func (s *Servers) replyPut(client net.Addr, sign string, id int64, state int, data []byte) {
 	reply := &Reply{
 		Type:      int(CommandPut),
 		CtxId:     id,
-		Data:      stateB,
-		StateCode: int(state),
+		Data:      data,
+		StateCode: state,
 	}
-	b, e := ObjToByte(reply)
+	b, e := s.encode(client, reply)
 	if e != nil {
 		Error("打包数据失败, e= ", e)
 	}
-	sign := SignGet(client.String())
-	data, err := PacketEncoder(CommandReply, s.name, sign, s.secretKey, b)
-	if err != nil {
-		Error(err)
-	}
-	s.Write(client, data)
+	s.send(client, CommandReply, sign, b)
 }
 
 // ReplyGet 返回put  state:0x0 成功   state:0x1 签名失败  state:2 业务层面的失败
-func (s *Servers) ReplyGet(client *net.UDPAddr, id int64, state int, data []byte) {
+func (s *Servers) ReplyGet(client net.Addr, id int64, state int, data []byte) {
+	s.replyGet(client, SignGet(client.String()), id, state, data)
+}
+
+// replyGet 使用请求携带的签名应答get
+func (s *Servers) replyGet(client net.Addr, sign string, id int64, state int, data []byte) {
 	reply := &Reply{
 		Type:      int(CommandGet),
 		CtxId:     id,
 		Data:      data,
 		StateCode: state,
 	}
-	b, e := ObjToByte(reply)
+	b, e := s.encode(client, reply)
 	if e != nil {
 		Error("打包数据失败, e= ", e)
 	}
-	sign := SignGet(client.String())
-	data, err := PacketEncoder(CommandReply, s.name, sign, s.secretKey, b)
-	if err != nil {
-		Error(err)
-	}
-	s.Write(client, data)
+	s.send(client, CommandReply, sign, b)
 }
 
 func (s *Servers) DefaultServersName() {
@@ -473,13 +887,32 @@ func (s *Servers) DefaultSecretKey() {
 }
 
 func (s *Servers) PutHandleFunc(label string, f func(s *Servers, c *ClientInfo, body []byte)) {
-	if _, ok := s.PutHandle[label]; ok {
+	s.checkPutHandle(label)
+	s.PutHandle[label] = f
+}
+
+// PutHandleFuncContext 注册PUT方法，返回的状态码与数据作为应答发给c端
+// 状态码不为 ReplyStateSuccess 时data为错误信息，c端的 PutWait 返回 *ReplyError
+func (s *Servers) PutHandleFuncContext(label string, f func(ctx context.Context, s *Servers, c *ClientInfo, body []byte) (int, []byte)) {
+	s.checkPutHandle(label)
+	s.putHandle[label] = f
+}
+
+func (s *Servers) checkPutHandle(label string) {
+	_, ok := s.PutHandle[label]
+	if _, ok2 := s.putHandle[label]; ok || ok2 {
 		PanicPutHandleFuncExist(label)
 	}
-	s.PutHandle[label] = f
 }
 
 func (s *Servers) GetHandleFunc(label string, f func(s *Servers, param []byte) (int, []byte)) {
+	s.GetHandleFuncContext(label, func(ctx context.Context, s *Servers, param []byte) (int, []byte) {
+		return f(s, param)
+	})
+}
+
+// GetHandleFuncContext 注册GET方法，ctx携带请求方的剩余超时时间
+func (s *Servers) GetHandleFuncContext(label string, f func(ctx context.Context, s *Servers, param []byte) (int, []byte)) {
 	if _, ok := s.GetHandle[label]; ok {
 		PanicGetHandleFuncExist(label)
 	}
@@ -490,108 +923,68 @@ func (s *Servers) GetServersName() string {
 	return s.name
 }
 
-func (s *Servers) clientJoin(name, ip string, addr *net.UDPAddr) {
-	client := &ClientConnectObj{
-		IP:   ip,
-		Addr: addr,
-		Last: time.Now().Unix(),
-	}
-	if _, ok := s.CMap[name]; !ok {
-		s.CMap[name] = make(map[string]*ClientConnectObj)
-	}
-	s.CMap[name][addr.String()] = client
-	s.onLineTable[fmt.Sprintf("%s@%s", name, ip)] = &ClientConnInfo{
-		Name:        name,
-		Online:      true,
-		IP:          ip,
-		Addr:        addr.String(),
-		LastTime:    time.Now().Unix(),
-		DiscardTime: 0,
-	}
-	return
-}
-
 func (s *Servers) ClientDiscard(name, ip string) {
 	if name == "" {
 		name = formatName(DefaultClientName)
 	}
-	if v, ok := s.CMap[name]; ok {
-		for k, c := range v {
-			Info(k, c.IP, ip)
-			delete(v, k)
-		}
-		if clientConnInfo := s.onLineTable[fmt.Sprintf("%s@%s", name, ip)]; clientConnInfo != nil {
-			clientConnInfo.Online = false
-			clientConnInfo.DiscardTime = time.Now().Unix()
-		}
+	for _, c := range s.Clients.discard(name, ip) {
+		s.peers.Delete(c.Addr.String())
+		s.fireClient(&s.hook.offline, newClientInfo(c.Name, c.Addr, 0))
 	}
 }
 
 func (s *Servers) GetClientAllName() []string {
-	nameList := make([]string, 0)
-	for name, _ := range s.CMap {
-		nameList = append(nameList, name)
-	}
-	return nameList
+	return s.Clients.Names()
 }
 
+// GetClientConn name下所有连接的快照 key= ip+port
 func (s *Servers) GetClientConn(name string) (map[string]*ClientConnectObj, bool) {
 	if name == "" {
 		name = DefaultClientName
 	}
-	name = formatName(name)
-	if v, ok := s.CMap[name]; ok && len(v) > 0 {
-		return v, true
-	}
-	return nil, false
+	return s.Clients.GetFromName(formatName(name))
 }
 
-func (s *Servers) GetClientConnFromIP(name, ip string) (*net.UDPAddr, bool) {
-	if list, ok := s.GetClientConn(name); ok {
-		for _, c := range list {
-			if ip == "" { // 如果未指定IP 则取 name下的随机一个IP
-				return c.Addr, true
-			}
-			if c.IP == ip {
-				return c.Addr, true
-			}
-		}
+func (s *Servers) GetClientConnFromIP(name, ip string) (net.Addr, bool) {
+	if name == "" {
+		name = DefaultClientName
+	}
+	c, ok := s.Clients.GetFromIP(formatName(name), ip)
+	if !ok {
+		return nil, false
 	}
-	return nil, false
+	return c.Addr, true
 }
 
 func (s *Servers) timeWheel() {
+	s.wg.Add(1)
 	go func() {
+		defer s.wg.Done()
 		tTime := time.Duration(ServersTimeWheel)
 		for {
 			timer := time.NewTimer(tTime * time.Second)
 			select {
+			case <-s.done:
+				timer.Stop()
+				return
 			case <-timer.C:
+				s.fragment.clean()
 				t := time.Now().Unix()
-				for k, v := range s.CMap {
-					for _, c := range v {
-						if t-c.Last > HeartbeatTimeLast { // 这个时间要大于5秒，因为来自c端的心跳就是5秒
-							InfoF("离线服务器名称:%s IP地址:%s  当前t=%d last=%d", k, c.IP, t, c.Last)
-							s.ClientDiscard(k, c.IP)
-						} else {
-							//InfoF("在线服务器名称:%s IP地址:%s  当前t=%d last=%d", k, c.IP, t, c.Last)
-						}
-					}
+				// 这个时间要大于5秒，因为来自c端的心跳就是5秒
+				// 只移除超时的连接，同一 name+ip 其他端口上的c端不受影响
+				for _, c := range s.Clients.expire(t - HeartbeatTimeLast) {
+					InfoF("离线服务器名称:%s 地址:%s  当前t=%d last=%d", c.Name, c.Addr, t, c.Last)
+					s.peers.Delete(c.Addr.String())
+					s.fireClient(&s.hook.offline, newClientInfo(c.Name, c.Addr, 0))
 				}
 			}
 		}
 	}()
 }
 
-// OnLineTable 获取当前客户端连接情况
+// OnLineTable 获取当前客户端连接情况的副本
 func (s *Servers) OnLineTable() map[string]*ClientConnInfo {
-	return s.onLineTable
+	return s.Clients.OnLineTable()
 }
 
 // TODO ... 拒绝指定客户端的通讯
-
-type ClientConnectObj struct {
-	IP   string
-	Addr *net.UDPAddr
-	Last int64 // 最后一次连接的时间
-}
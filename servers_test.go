@@ -0,0 +1,117 @@
+package udp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/mangenotwork/udp_comm/simnet"
+)
+
+// waitGoroutines 等待协程数回落到 n 以内
+func waitGoroutines(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > n {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			t.Fatalf("协程未退出 %d > %d\n%s", runtime.NumGoroutine(), n, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// runErr 在协程中运行 run，返回其结果
+func runErr(run func() error) <-chan error {
+	ch := make(chan error, 1)
+	go func() {
+		ch <- run()
+	}()
+	return ch
+}
+
+func waitRun(t *testing.T, ch <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-ch:
+		return err
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run 未返回")
+	}
+	return nil
+}
+
+func newSimServers(t *testing.T, network *simnet.Network, addr string) (*Servers, *simnet.Conn) {
+	t.Helper()
+	conn, err := network.Listen(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewServersWithConn(conn, ServersConf{Name: DefaultServersName, ConnectCode: DefaultConnectCode, SecretKey: DefaultSecretKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, conn
+}
+
+func TestServersShutdown(t *testing.T) {
+	network := simnet.New(simnet.Conf{}, 1)
+	base := runtime.NumGoroutine()
+	s, _ := newSimServers(t, network, "servers")
+	run := runErr(s.Run)
+	time.Sleep(20 * time.Millisecond)
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitRun(t, run); err != ErrServersClosed {
+		t.Fatalf("Run err = %v", err)
+	}
+	if err := s.Shutdown(context.Background()); err != ErrServersClosed {
+		t.Fatalf("再次 Shutdown err = %v", err)
+	}
+	waitGoroutines(t, base)
+}
+
+func TestServersShutdownBeforeRun(t *testing.T) {
+	network := simnet.New(simnet.Conf{}, 1)
+	base := runtime.NumGoroutine()
+	s, _ := newSimServers(t, network, "servers")
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Run(); err != ErrServersClosed {
+		t.Fatalf("Run err = %v", err)
+	}
+	waitGoroutines(t, base)
+
+	// Run 与 Shutdown 同时调用
+	for i := 0; i < 20; i++ {
+		s, _ := newSimServers(t, network, "race")
+		run := runErr(s.Run)
+		if err := s.Shutdown(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if err := waitRun(t, run); err != ErrServersClosed {
+			t.Fatalf("Run err = %v", err)
+		}
+	}
+	waitGoroutines(t, base)
+}
+
+// TestServersConnClosed 连接被外部关闭时 Run 返回错误而不是一直重试
+func TestServersConnClosed(t *testing.T) {
+	network := simnet.New(simnet.Conf{}, 1)
+	base := runtime.NumGoroutine()
+	s, conn := newSimServers(t, network, "servers")
+	run := runErr(s.Run)
+	time.Sleep(20 * time.Millisecond)
+	_ = conn.Close()
+	if err := waitRun(t, run); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("Run err = %v", err)
+	}
+	_ = s.Shutdown(context.Background())
+	waitGoroutines(t, base)
+}
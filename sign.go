@@ -18,18 +18,35 @@ func createSign() string {
 	return string(b)
 }
 
-var signMap sync.Map
+// signMap ip+port -> *signPair
+// 每次连接与心跳都会下发新的签名，应答可能乱序到达，上一个签名在下一次更新前仍然有效
+var (
+	signMap  sync.Map
+	signLock sync.Mutex
+)
+
+type signPair struct {
+	current string
+	prev    string
+}
 
 func SignStore(addr, sign string) {
-	signMap.Store(addr, sign)
+	signLock.Lock()
+	defer signLock.Unlock()
+	pair := &signPair{current: sign}
+	if v, ok := signMap.Load(addr); ok {
+		pair.prev = v.(*signPair).current
+	}
+	signMap.Store(addr, pair)
 }
 
 func SignCheck(addr, sign string) bool {
 	v, ok := signMap.Load(addr)
-	if ok && v.(string) == sign {
-		return true
+	if !ok {
+		return false
 	}
-	return false
+	pair := v.(*signPair)
+	return pair.current == sign || (pair.prev != "" && pair.prev == sign)
 }
 
 func SignGet(addr string) string {
@@ -37,5 +54,5 @@ func SignGet(addr string) string {
 	if !ok {
 		return ""
 	}
-	return v.(string)
+	return v.(*signPair).current
 }
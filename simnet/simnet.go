@@ -0,0 +1,316 @@
+// Package simnet 进程内模拟的数据包网络，用于测试弱网环境下的可靠性
+//
+// 网络中的每个 Conn 都实现了 net.PacketConn，可以通过 NewServersWithConn, NewClientWithConn 运行 Servers 与 Client，
+// 发出的每个包按 Conf 模拟丢包、重复、乱序、延迟与抖动，Partition 可以断开两个地址之间的通讯
+package simnet
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const queueSize = 1024 // 每个连接接收队列的长度，队列满时丢包
+
+var ErrAddrInUse = errors.New("simnet: 地址已被使用")
+
+// Addr 模拟网络中的地址
+type Addr string
+
+func (a Addr) Network() string {
+	return "simnet"
+}
+
+func (a Addr) String() string {
+	return string(a)
+}
+
+// Conf 网络状况，概率的取值为 0~1
+type Conf struct {
+	Loss         float64       // 丢包率
+	Duplicate    float64       // 包被重复发送一次的概率
+	Reorder      float64       // 包被额外延迟 ReorderDelay 的概率，使之后发出的包先到达
+	ReorderDelay time.Duration // 乱序时额外的延迟 默认 2*(Delay+Jitter)+1ms
+	Delay        time.Duration // 固定延迟
+	Jitter       time.Duration // 随机抖动 [0, Jitter)
+}
+
+// Stats 网络的统计
+type Stats struct {
+	Sent       uint64 // 发出的包数
+	Delivered  uint64 // 到达接收队列的包数(含重复)
+	Lost       uint64 // 模拟丢包的包数
+	Duplicated uint64 // 被重复的包数
+	Reordered  uint64 // 被乱序的包数
+	Blocked    uint64 // 因网络分区或地址不存在丢弃的包数
+	Overflow   uint64 // 接收队列满丢弃的包数
+}
+
+// Network 模拟的网络
+type Network struct {
+	mu         sync.Mutex
+	conf       Conf
+	rand       *rand.Rand
+	conns      map[Addr]*Conn
+	partitions map[[2]Addr]struct{}
+	stats      Stats
+}
+
+// New 创建模拟网络，seed 相同时随机的网络状况相同(不考虑协程调度)
+func New(conf Conf, seed int64) *Network {
+	return &Network{
+		conf:       conf,
+		rand:       rand.New(rand.NewSource(seed)),
+		conns:      make(map[Addr]*Conn),
+		partitions: make(map[[2]Addr]struct{}),
+	}
+}
+
+// SetConf 修改网络状况，对之后发出的包生效
+func (n *Network) SetConf(conf Conf) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.conf = conf
+}
+
+// Listen 在地址 addr 上创建连接
+func (n *Network) Listen(addr string) (*Conn, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	a := Addr(addr)
+	if _, ok := n.conns[a]; ok {
+		return nil, ErrAddrInUse
+	}
+	c := &Conn{
+		network:    n,
+		addr:       a,
+		queue:      make(chan packet, queueSize),
+		closed:     make(chan struct{}),
+		deadlineCh: make(chan struct{}),
+	}
+	n.conns[a] = c
+	return c, nil
+}
+
+func partitionKey(a, b Addr) [2]Addr {
+	if a > b {
+		a, b = b, a
+	}
+	return [2]Addr{a, b}
+}
+
+// Partition 断开 a 与 b 之间双向的通讯
+func (n *Network) Partition(a, b net.Addr) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.partitions[partitionKey(Addr(a.String()), Addr(b.String()))] = struct{}{}
+}
+
+// Heal 恢复 a 与 b 之间的通讯
+func (n *Network) Heal(a, b net.Addr) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.partitions, partitionKey(Addr(a.String()), Addr(b.String())))
+}
+
+// HealAll 恢复所有的网络分区
+func (n *Network) HealAll() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.partitions = make(map[[2]Addr]struct{})
+}
+
+// Stats 网络的统计
+func (n *Network) Stats() Stats {
+	return Stats{
+		Sent:       atomic.LoadUint64(&n.stats.Sent),
+		Delivered:  atomic.LoadUint64(&n.stats.Delivered),
+		Lost:       atomic.LoadUint64(&n.stats.Lost),
+		Duplicated: atomic.LoadUint64(&n.stats.Duplicated),
+		Reordered:  atomic.LoadUint64(&n.stats.Reordered),
+		Blocked:    atomic.LoadUint64(&n.stats.Blocked),
+		Overflow:   atomic.LoadUint64(&n.stats.Overflow),
+	}
+}
+
+// route 计算一个包的命运，返回每个副本的延迟，丢弃时返回nil
+func (n *Network) route(from, to Addr) []time.Duration {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	atomic.AddUint64(&n.stats.Sent, 1)
+	if _, ok := n.partitions[partitionKey(from, to)]; ok {
+		atomic.AddUint64(&n.stats.Blocked, 1)
+		return nil
+	}
+	conf := n.conf
+	if n.rand.Float64() < conf.Loss {
+		atomic.AddUint64(&n.stats.Lost, 1)
+		return nil
+	}
+	copies := 1
+	if n.rand.Float64() < conf.Duplicate {
+		atomic.AddUint64(&n.stats.Duplicated, 1)
+		copies = 2
+	}
+	delays := make([]time.Duration, copies)
+	for i := range delays {
+		delay := conf.Delay
+		if conf.Jitter > 0 {
+			delay += time.Duration(n.rand.Int63n(int64(conf.Jitter)))
+		}
+		if n.rand.Float64() < conf.Reorder {
+			atomic.AddUint64(&n.stats.Reordered, 1)
+			if conf.ReorderDelay > 0 {
+				delay += conf.ReorderDelay
+			} else {
+				delay += 2*(conf.Delay+conf.Jitter) + time.Millisecond
+			}
+		}
+		delays[i] = delay
+	}
+	return delays
+}
+
+// deliver 包到达接收方的队列，接收方不存在、已关闭或队列满时丢弃
+func (n *Network) deliver(p packet, to Addr) {
+	n.mu.Lock()
+	c, ok := n.conns[to]
+	n.mu.Unlock()
+	if !ok {
+		atomic.AddUint64(&n.stats.Blocked, 1)
+		return
+	}
+	select {
+	case <-c.closed:
+		atomic.AddUint64(&n.stats.Blocked, 1)
+	case c.queue <- p:
+		atomic.AddUint64(&n.stats.Delivered, 1)
+	default:
+		atomic.AddUint64(&n.stats.Overflow, 1)
+	}
+}
+
+func (n *Network) remove(c *Conn) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conns[c.addr] == c {
+		delete(n.conns, c.addr)
+	}
+}
+
+type packet struct {
+	data []byte
+	from Addr
+}
+
+// Conn 模拟网络中的连接，实现 net.PacketConn
+type Conn struct {
+	network    *Network
+	addr       Addr
+	queue      chan packet
+	closed     chan struct{}
+	closeOnce  sync.Once
+	mu         sync.Mutex
+	deadline   time.Time
+	deadlineCh chan struct{} // 读取截止时间变化时关闭并替换，唤醒阻塞中的读取
+}
+
+func (c *Conn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		select {
+		case <-c.closed:
+			return 0, nil, net.ErrClosed
+		default:
+		}
+		c.mu.Lock()
+		deadline, deadlineCh := c.deadline, c.deadlineCh
+		c.mu.Unlock()
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			d := time.Until(deadline)
+			if d <= 0 {
+				return 0, nil, os.ErrDeadlineExceeded
+			}
+			timer = time.NewTimer(d)
+			timeout = timer.C
+		}
+		select {
+		case pkt := <-c.queue:
+			stopTimer(timer)
+			return copy(p, pkt.data), pkt.from, nil
+		case <-c.closed:
+			stopTimer(timer)
+			return 0, nil, net.ErrClosed
+		case <-timeout:
+			return 0, nil, os.ErrDeadlineExceeded
+		case <-deadlineCh:
+			// 截止时间变化，重新计算
+			stopTimer(timer)
+		}
+	}
+}
+
+func stopTimer(t *time.Timer) {
+	if t != nil {
+		t.Stop()
+	}
+}
+
+func (c *Conn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	to := Addr(addr.String())
+	delays := c.network.route(c.addr, to)
+	for _, delay := range delays {
+		// 调用方可能复用缓冲区，每个副本独立复制
+		pkt := packet{data: append([]byte(nil), p...), from: c.addr}
+		if delay <= 0 {
+			c.network.deliver(pkt, to)
+			continue
+		}
+		time.AfterFunc(delay, func() {
+			c.network.deliver(pkt, to)
+		})
+	}
+	return len(p), nil
+}
+
+func (c *Conn) Close() error {
+	err := net.ErrClosed
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.network.remove(c)
+		err = nil
+	})
+	return err
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	close(c.deadlineCh)
+	c.deadlineCh = make(chan struct{})
+	return nil
+}
+
+// SetWriteDeadline 写入不会阻塞，截止时间不生效
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
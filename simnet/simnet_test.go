@@ -0,0 +1,164 @@
+package simnet
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func listen(t *testing.T, n *Network, addr string) *Conn {
+	t.Helper()
+	c, err := n.Listen(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = c.Close()
+	})
+	return c
+}
+
+// recvAll 读取直到 wait 时间内没有新的包
+func recvAll(t *testing.T, c *Conn, wait time.Duration) []string {
+	t.Helper()
+	list := make([]string, 0)
+	buf := make([]byte, 64)
+	for {
+		_ = c.SetReadDeadline(time.Now().Add(wait))
+		n, _, err := c.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrDeadlineExceeded) {
+				t.Fatal(err)
+			}
+			return list
+		}
+		list = append(list, string(buf[:n]))
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	n := New(Conf{}, 1)
+	a, b := listen(t, n, "a"), listen(t, n, "b")
+	if _, err := n.Listen("a"); err != ErrAddrInUse {
+		t.Fatalf("重复监听 err = %v", err)
+	}
+	if _, err := a.WriteTo([]byte("hello"), b.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	size, from, err := b.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:size]) != "hello" || from.String() != "a" {
+		t.Fatalf("收到 %q from %s", buf[:size], from)
+	}
+}
+
+func TestLossAndDuplicate(t *testing.T) {
+	n := New(Conf{Loss: 0.3, Duplicate: 0.2}, 1)
+	a, b := listen(t, n, "a"), listen(t, n, "b")
+	total := 500
+	for i := 0; i < total; i++ {
+		_, _ = a.WriteTo([]byte{byte(i)}, b.LocalAddr())
+	}
+	got := recvAll(t, b, 20*time.Millisecond)
+	stats := n.Stats()
+	if stats.Lost == 0 || stats.Duplicated == 0 {
+		t.Fatalf("没有模拟丢包或重复 %+v", stats)
+	}
+	if want := int(stats.Sent-stats.Lost) + int(stats.Duplicated); len(got) != want {
+		t.Fatalf("收到 %d 个包, 应为 %d", len(got), want)
+	}
+	if rate := float64(stats.Lost) / float64(total); rate < 0.2 || rate > 0.4 {
+		t.Fatalf("丢包率 %.2f 偏离配置", rate)
+	}
+}
+
+func TestReorder(t *testing.T) {
+	n := New(Conf{Reorder: 0.5, ReorderDelay: 5 * time.Millisecond}, 1)
+	a, b := listen(t, n, "a"), listen(t, n, "b")
+	for i := 0; i < 50; i++ {
+		_, _ = a.WriteTo([]byte{byte(i)}, b.LocalAddr())
+	}
+	got := recvAll(t, b, 30*time.Millisecond)
+	if len(got) != 50 {
+		t.Fatalf("收到 %d 个包", len(got))
+	}
+	ordered := true
+	for i := 1; i < len(got); i++ {
+		if got[i][0] < got[i-1][0] {
+			ordered = false
+		}
+	}
+	if ordered {
+		t.Fatal("包没有乱序")
+	}
+}
+
+func TestDelay(t *testing.T) {
+	n := New(Conf{Delay: 20 * time.Millisecond, Jitter: 5 * time.Millisecond}, 1)
+	a, b := listen(t, n, "a"), listen(t, n, "b")
+	start := time.Now()
+	_, _ = a.WriteTo([]byte("x"), b.LocalAddr())
+	buf := make([]byte, 8)
+	if _, _, err := b.ReadFrom(buf); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Fatalf("延迟 %s 小于配置", d)
+	}
+}
+
+func TestPartition(t *testing.T) {
+	n := New(Conf{}, 1)
+	a, b, c := listen(t, n, "a"), listen(t, n, "b"), listen(t, n, "c")
+	n.Partition(a.LocalAddr(), b.LocalAddr())
+	_, _ = a.WriteTo([]byte("x"), b.LocalAddr())
+	_, _ = b.WriteTo([]byte("x"), a.LocalAddr())
+	_, _ = a.WriteTo([]byte("x"), c.LocalAddr())
+	if got := recvAll(t, b, 5*time.Millisecond); len(got) != 0 {
+		t.Fatal("分区后仍收到包")
+	}
+	if got := recvAll(t, c, 5*time.Millisecond); len(got) != 1 {
+		t.Fatal("分区影响了其他地址")
+	}
+	n.Heal(a.LocalAddr(), b.LocalAddr())
+	_, _ = a.WriteTo([]byte("x"), b.LocalAddr())
+	if got := recvAll(t, b, 5*time.Millisecond); len(got) != 1 {
+		t.Fatal("恢复后没有收到包")
+	}
+}
+
+func TestDeadlineAndClose(t *testing.T) {
+	n := New(Conf{}, 1)
+	a := listen(t, n, "a")
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := a.ReadFrom(make([]byte, 8))
+		done <- err
+	}()
+	time.Sleep(5 * time.Millisecond)
+	// 与 Shutdown 相同，设置截止时间使阻塞中的读取立即返回
+	_ = a.SetReadDeadline(time.Now())
+	select {
+	case err := <-done:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("err = %v", err)
+		}
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			t.Fatal("截止时间的错误应为 Timeout")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("设置截止时间后读取没有返回")
+	}
+	_ = a.Close()
+	if _, _, err := a.ReadFrom(make([]byte, 8)); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("关闭后读取 err = %v", err)
+	}
+	if _, err := n.Listen("a"); err != nil {
+		t.Fatal("关闭后地址应可以重新使用")
+	}
+}
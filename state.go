@@ -0,0 +1,84 @@
+package udp
+
+import (
+	"sync/atomic"
+)
+
+// ClientState client与servers的连接状态
+type ClientState int32
+
+const (
+	StateConnecting   ClientState = iota // 已发送连接请求，等待servers下发签名
+	StateConnected                       // 已连接，心跳正常应答
+	StateDegraded                        // 已连接，但有心跳未收到应答
+	StateDisconnected                    // 连续 HeartbeatMaxMiss 个心跳未收到应答，判定servers丢失
+	StateClosed                          // 已关闭
+)
+
+func (s ClientState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateDegraded:
+		return "degraded"
+	case StateDisconnected:
+		return "disconnected"
+	case StateClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// State 当前的连接状态
+func (c *Client) State() ClientState {
+	return ClientState(atomic.LoadInt32(&c.state))
+}
+
+// OnStateChange 连接状态变化时的回调，在触发变化的协程中同步执行，应尽快返回
+func (c *Client) OnStateChange(fn func(c *Client, old, new ClientState)) {
+	c.hook.mu.Lock()
+	defer c.hook.mu.Unlock()
+	c.hook.stateChange = append(c.hook.stateChange, fn)
+}
+
+// setState 切换连接状态，Closed 之后不再变化
+func (c *Client) setState(state ClientState) {
+	for {
+		old := c.State()
+		if old == state || old == StateClosed {
+			return
+		}
+		if atomic.CompareAndSwapInt32(&c.state, int32(old), int32(state)) {
+			InfoF("client 连接状态 %s -> %s", old, state)
+			c.hook.mu.RLock()
+			fns := c.hook.stateChange
+			c.hook.mu.RUnlock()
+			for _, fn := range fns {
+				fn(c, old, state)
+			}
+			return
+		}
+	}
+}
+
+// online 已连接，可以直接发送数据
+func (c *Client) online() bool {
+	state := c.State()
+	return state == StateConnected || state == StateDegraded
+}
+
+// heartbeatMiss 心跳的时间节点，根据未应答的心跳数更新连接状态
+func (c *Client) heartbeatMiss() {
+	miss := atomic.LoadInt32(&c.missed)
+	switch {
+	case miss >= int32(c.heartbeatMaxMiss):
+		if c.State() != StateDisconnected {
+			c.setState(StateDisconnected)
+			c.reconnect()
+		}
+	case miss >= 1 && c.State() == StateConnected:
+		c.setState(StateDegraded)
+	}
+}
@@ -0,0 +1,99 @@
+package udp
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mangenotwork/udp_comm/simnet"
+)
+
+// newStateClient 未运行的Client，重连等待足够长，测试期间不会真正重连
+func newStateClient(t *testing.T) *Client {
+	t.Helper()
+	c, _ := newSimClient(t, simnet.New(simnet.Conf{}, 1), "client", func(cConf *ClientConf) {
+		cConf.HeartbeatMaxMiss = 3
+		cConf.Reconnect = ReconnectPolicy{InitialDelay: time.Hour}
+	})
+	t.Cleanup(func() {
+		_ = c.Shutdown(context.Background())
+	})
+	return c
+}
+
+type stateChange struct {
+	old, new ClientState
+}
+
+// recordState 记录状态变化的回调
+func recordState(c *Client) *[]stateChange {
+	changes := &[]stateChange{}
+	c.OnStateChange(func(c *Client, old, new ClientState) {
+		*changes = append(*changes, stateChange{old, new})
+	})
+	return changes
+}
+
+// TestSetState 状态切换与回调，相同状态不触发回调，Closed 之后不再变化
+func TestSetState(t *testing.T) {
+	all := []ClientState{StateConnecting, StateConnected, StateDegraded, StateDisconnected, StateClosed}
+	for _, from := range all {
+		for _, to := range all {
+			t.Run(from.String()+"->"+to.String(), func(t *testing.T) {
+				c := newStateClient(t)
+				atomic.StoreInt32(&c.state, int32(from))
+				changes := recordState(c)
+				c.setState(to)
+
+				legal := from != to && from != StateClosed
+				want := from
+				if legal {
+					want = to
+				}
+				if c.State() != want {
+					t.Fatalf("state = %s, 应为 %s", c.State(), want)
+				}
+				switch {
+				case legal && (len(*changes) != 1 || (*changes)[0] != stateChange{from, to}):
+					t.Fatalf("回调 %v", *changes)
+				case !legal && len(*changes) != 0:
+					t.Fatalf("不应触发回调 %v", *changes)
+				}
+			})
+		}
+	}
+}
+
+// TestHeartbeatMiss 未应答的心跳数驱动 Connected -> Degraded -> Disconnected
+func TestHeartbeatMiss(t *testing.T) {
+	cases := []struct {
+		from   ClientState
+		missed int32
+		want   ClientState
+	}{
+		{StateConnected, 0, StateConnected},
+		{StateConnected, 1, StateDegraded},
+		{StateConnected, 3, StateDisconnected},
+		{StateDegraded, 1, StateDegraded},
+		{StateDegraded, 2, StateDegraded},
+		{StateDegraded, 3, StateDisconnected},
+		{StateConnecting, 1, StateConnecting},
+		{StateConnecting, 3, StateDisconnected},
+		{StateDisconnected, 5, StateDisconnected},
+		{StateClosed, 5, StateClosed},
+	}
+	for _, tc := range cases {
+		c := newStateClient(t)
+		atomic.StoreInt32(&c.state, int32(tc.from))
+		atomic.StoreInt32(&c.missed, tc.missed)
+		changes := recordState(c)
+		c.heartbeatMiss()
+		if c.State() != tc.want {
+			t.Fatalf("%s missed:%d state = %s, 应为 %s", tc.from, tc.missed, c.State(), tc.want)
+		}
+		if n := len(*changes); (tc.from != tc.want) != (n == 1) || n > 1 {
+			t.Fatalf("%s missed:%d 回调 %v", tc.from, tc.missed, *changes)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package udp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+/*
+
+带类型的处理方法与请求
+数据使用与信封结构相同的编码(ServersConf/ClientConf 的 Codec)，两端的类型需要一致
+解码失败时应答 ReplyStateDecodeErr，处理方法返回错误时应答 ReplyStateCustom，请求方收到 *ReplyError
+
+*/
+
+// ReplyError 对端处理失败的应答
+type ReplyError struct {
+	StateCode int    // ReplyStateCustom:处理方法返回错误  ReplyStateDecodeErr:数据解码失败
+	Msg       string // 对端的错误信息
+}
+
+func (e *ReplyError) Error() string {
+	return fmt.Sprintf("对端处理失败 state:%d err:%s", e.StateCode, e.Msg)
+}
+
+func replyError(state int, data []byte) error {
+	if state == ReplyStateSuccess {
+		return nil
+	}
+	return &ReplyError{StateCode: state, Msg: string(data)}
+}
+
+type codecKey struct{}
+
+// withCodec 将对端使用的编码放入处理方法的ctx
+func withCodec(ctx context.Context, codec CodecType) context.Context {
+	return context.WithValue(ctx, codecKey{}, codec)
+}
+
+func codecFrom(ctx context.Context) CodecType {
+	if codec, ok := ctx.Value(codecKey{}).(CodecType); ok {
+		return codec
+	}
+	return CodecBinary
+}
+
+// HandlePut 注册带类型的PUT方法，c端使用 Put 或 PutWait 发送
+func HandlePut[T any](s *Servers, label string, fn func(ctx context.Context, c *ClientInfo, v T) error) {
+	s.PutHandleFuncContext(label, func(ctx context.Context, s *Servers, c *ClientInfo, body []byte) (int, []byte) {
+		var v T
+		if err := decodeObj(codecFrom(ctx), body, &v); err != nil {
+			return ReplyStateDecodeErr, []byte(ErrDecode(label, err).Error())
+		}
+		if err := fn(ctx, c, v); err != nil {
+			return ReplyStateCustom, []byte(err.Error())
+		}
+		return ReplyStateSuccess, nil
+	})
+}
+
+// HandleGet 注册带类型的GET方法，c端使用 Get 或 GetContext 请求
+func HandleGet[Req, Resp any](s *Servers, label string, fn func(ctx context.Context, req Req) (Resp, error)) {
+	s.GetHandleFuncContext(label, func(ctx context.Context, s *Servers, param []byte) (int, []byte) {
+		var req Req
+		codec := codecFrom(ctx)
+		if err := decodeObj(codec, param, &req); err != nil {
+			return ReplyStateDecodeErr, []byte(ErrDecode(label, err).Error())
+		}
+		resp, err := fn(ctx, req)
+		if err != nil {
+			return ReplyStateCustom, []byte(err.Error())
+		}
+		b, err := encodeObj(codec, resp)
+		if err != nil {
+			return ReplyStateCustom, []byte(err.Error())
+		}
+		return ReplyStateSuccess, b
+	})
+}
+
+// HandleNotice 注册带类型的通知方法，servers端使用 Notice 发送
+// 通知在处理前已确认，解码失败与fn返回的错误只记录日志
+func HandleNotice[T any](c *Client, label string, fn func(ctx context.Context, c *Client, v T) error) {
+	c.NoticeHandleFunc(label, func(c *Client, data []byte) {
+		var v T
+		if err := decodeObj(c.codec, data, &v); err != nil {
+			Error(ErrDecode(label, err))
+			return
+		}
+		if err := fn(context.Background(), c, v); err != nil {
+			Error("通知处理失败 label:", label, " err:", err)
+		}
+	})
+}
+
+// Put 发送带类型的数据，不等待确认
+func Put[T any](c *Client, label string, v T) error {
+	b, err := encodeObj(c.codec, v)
+	if err != nil {
+		return err
+	}
+	return c.put(PutData{
+		Label: label,
+		Id:    id(),
+		Body:  b,
+	})
+}
+
+// PutWait 发送带类型的数据并等待确认，servers端解码或处理失败时返回 *ReplyError
+func PutWait[T any](ctx context.Context, c *Client, label string, v T) error {
+	b, err := encodeObj(c.codec, v)
+	if err != nil {
+		return err
+	}
+	return c.PutWait(ctx, label, b)
+}
+
+// Get 带类型的请求，超时时间与 Client.Get 相同
+func Get[Req, Resp any](c *Client, label string, req Req) (Resp, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultSGetTimeOut*time.Millisecond)
+	defer cancel()
+	return GetContext[Req, Resp](ctx, c, label, req)
+}
+
+// GetContext 带类型的请求，servers端解码或处理失败时返回 *ReplyError
+func GetContext[Req, Resp any](ctx context.Context, c *Client, label string, req Req) (Resp, error) {
+	var resp Resp
+	param, err := encodeObj(c.codec, req)
+	if err != nil {
+		return resp, err
+	}
+	state, b, err := c.getContext(ctx, label, param)
+	if err != nil {
+		return resp, err
+	}
+	if err = replyError(state, b); err != nil {
+		return resp, err
+	}
+	if err = decodeObj(c.codec, b, &resp); err != nil {
+		return resp, ErrDecode(label, err)
+	}
+	return resp, nil
+}
+
+// Notice 下发带类型的通知，按每个c端的编码编码
+func Notice[T any](s *Servers, name, label string, v T, retryConf *NoticeRetry) (string, error) {
+	return s.notice(name, label, func(addr net.Addr) ([]byte, error) {
+		return encodeObj(s.packetConf(addr).codec, v)
+	}, retryConf)
+}
@@ -0,0 +1,124 @@
+package udp
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mangenotwork/udp_comm/simnet"
+)
+
+type order struct {
+	Id    int64
+	Items []string
+	Price float64
+}
+
+type quote struct {
+	Symbol string
+	Bid    float64
+}
+
+func TestTypedPut(t *testing.T) {
+	network := simnet.New(simnet.Conf{}, 1)
+	got := make(chan order, 1)
+	_, c := simPair(t, network, func(s *Servers, c *Client) {
+		HandlePut(s, "order", func(ctx context.Context, info *ClientInfo, v order) error {
+			if v.Price < 0 {
+				return errors.New("价格不能为负数")
+			}
+			select {
+			case got <- v:
+			default: // 应答丢失时c端会重传，重复送达
+			}
+			return nil
+		})
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	want := order{Id: 1, Items: []string{"a", "b"}, Price: 9.5}
+	if err := PutWait(ctx, c, "order", want); err != nil {
+		t.Fatal(err)
+	}
+	if v := <-got; v.Id != want.Id || len(v.Items) != 2 || v.Price != want.Price {
+		t.Fatalf("收到 %+v", v)
+	}
+
+	var replyErr *ReplyError
+	err := PutWait(ctx, c, "order", order{Id: 2, Price: -1})
+	if !errors.As(err, &replyErr) || replyErr.StateCode != ReplyStateCustom || replyErr.Msg != "价格不能为负数" {
+		t.Fatalf("err = %v", err)
+	}
+	err = PutWait(ctx, c, "order", "not an order")
+	if !errors.As(err, &replyErr) || replyErr.StateCode != ReplyStateDecodeErr {
+		t.Fatalf("err = %v", err)
+	}
+	if n := c.backlog.Len(); n != 0 {
+		t.Fatalf("处理失败的数据不应留在积压中 len = %d", n)
+	}
+}
+
+func TestTypedGet(t *testing.T) {
+	network := simnet.New(simnet.Conf{}, 1)
+	_, c := simPairConf(t, network, func(sConf *ServersConf, cConf *ClientConf) {
+		cConf.Codec = CodecJSON
+	}, func(s *Servers, c *Client) {
+		HandleGet(s, "quote", func(ctx context.Context, symbol string) (quote, error) {
+			if symbol == "" {
+				return quote{}, errors.New("symbol 为空")
+			}
+			return quote{Symbol: symbol, Bid: 1.25}, nil
+		})
+	})
+	q, err := Get[string, quote](c, "quote", "UDP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Symbol != "UDP" || q.Bid != 1.25 {
+		t.Fatalf("q = %+v", q)
+	}
+
+	var replyErr *ReplyError
+	_, err = Get[string, quote](c, "quote", "")
+	if !errors.As(err, &replyErr) || replyErr.StateCode != ReplyStateCustom {
+		t.Fatalf("err = %v", err)
+	}
+	_, err = Get[quote, quote](c, "quote", quote{Symbol: "UDP"})
+	if !errors.As(err, &replyErr) || replyErr.StateCode != ReplyStateDecodeErr {
+		t.Fatalf("err = %v", err)
+	}
+	// 原始的Get不受影响，仍然返回应答的数据
+	rse, err := c.Get("quote", []byte(`"UDP"`))
+	if err != nil || len(rse) == 0 {
+		t.Fatalf("rse = %s err = %v", rse, err)
+	}
+}
+
+func TestTypedNotice(t *testing.T) {
+	network := simnet.New(simnet.Conf{}, 1)
+	got := make(chan quote, 1)
+	s, _ := simPairConf(t, network, func(sConf *ServersConf, cConf *ClientConf) {
+		cConf.Codec = CodecJSON
+	}, func(s *Servers, c *Client) {
+		HandleNotice(c, "quote", func(ctx context.Context, c *Client, v quote) error {
+			select {
+			case got <- v:
+			default: // 确认丢失时servers端会重发，重复送达
+			}
+			return nil
+		})
+	})
+	if _, err := Notice(s, "sim", "quote", quote{Symbol: "UDP", Bid: 2}, nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case v := <-got:
+		if v.Symbol != "UDP" || v.Bid != 2 {
+			t.Fatalf("v = %+v", v)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("未收到通知")
+	}
+}
@@ -4,12 +4,14 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"net"
 	"os"
 	"runtime"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 )
 
 func int64ToBytes(n int64) ([]byte, error) {
@@ -34,15 +36,55 @@ func bytesToInt64(bys []byte) (int64, error) {
 	return data, err
 }
 
+// formatName 去掉v0包头中name的补齐位，兼容传入补齐后的name
 func formatName(str string) string {
-	ln := len(str)
-	if ln > 0 && ln <= 7 {
-		// 补齐位
-		for i := 0; i < 7-ln; i++ {
-			str += " "
-		}
+	return strings.TrimRight(str, " ")
+}
+
+// checkName name不能超过 NameMaxLen 个字节，且为有效的UTF-8
+func checkName(name string) error {
+	if len(name) > NameMaxLen {
+		return ErrNmeLengthAbove
+	}
+	if !utf8.ValidString(name) {
+		return ErrNameInvalid
+	}
+	return nil
+}
+
+// truncateName 截断name不超过max个字节，不会截断多字节的字符
+func truncateName(name string, max int) string {
+	if len(name) <= max {
+		return name
+	}
+	name = name[:max]
+	for len(name) > 0 && !utf8.ValidString(name) {
+		name = name[:len(name)-1]
+	}
+	return name
+}
+
+// addrIP 连接地址的ip，非IP网络的地址(如unix socket)返回完整地址
+func addrIP(addr net.Addr) string {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP.String()
+	case *net.TCPAddr:
+		return a.IP.String()
+	case *net.IPAddr:
+		return a.IP.String()
+	}
+	return addr.String()
+}
+
+// sameAddr 两个地址是否相同，IPv4与映射到IPv6的IPv4视为相同，IPv6链路本地地址的zone不同时视为不同
+func sameAddr(a, b net.Addr) bool {
+	ua, ok1 := a.(*net.UDPAddr)
+	ub, ok2 := b.(*net.UDPAddr)
+	if ok1 && ok2 {
+		return ua.Port == ub.Port && ua.IP.Equal(ub.IP) && ua.Zone == ub.Zone
 	}
-	return str
+	return a.Network() == b.Network() && a.String() == b.String()
 }
 
 // LogClose 是否关闭日志